@@ -0,0 +1,242 @@
+// Command mathengine evaluates math-engine expressions from the shell.
+//
+//	mathengine "2*(3+4)"       prints 14
+//	echo "1+2" | mathengine    evaluates each line of stdin
+//	mathengine                 starts an interactive REPL
+//
+// a line is a program of statements separated by ';', "x = 2" assigns a variable
+// that the following lines can use, ans is the result of the last line.
+// the REPL commands start with ':', :help lists them.
+// the history of the REPL is kept in ~/.mathengine_history, or in the file
+// named by MATHENGINE_HISTORY, which disables it if it is empty.
+// the exit code is 1 if an expression fails
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dengsgo/math-engine/engine"
+)
+
+const help = `:ast <expr>   print the parse tree of expr
+:vars         list the variables
+:history      list the lines entered
+:help         print this help
+:quit         leave, like exit or an end of file`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, isTerminal(os.Stdin)))
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// run is the command with its arguments and streams, it returns the exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer, interactive bool) int {
+	s := &session{vars: map[string]float64{}, out: stdout, errOut: stderr}
+	if len(args) > 0 {
+		if len(args) == 1 && (args[0] == "-h" || args[0] == "--help") {
+			fmt.Fprintln(stdout, "usage: mathengine [expression]")
+			return 0
+		}
+		if !s.exec(strings.Join(args, " ")) {
+			return 1
+		}
+		return 0
+	}
+	if !interactive {
+		code := 0
+		sc := bufio.NewScanner(stdin)
+		for sc.Scan() {
+			if _, ok := s.line(sc.Text()); !ok {
+				code = 1
+			}
+		}
+		if err := sc.Err(); err != nil {
+			fmt.Fprintln(stderr, "ERROR: "+err.Error())
+			return 1
+		}
+		return code
+	}
+	s.historyFile = historyFile()
+	s.loadHistory()
+	s.repl(stdin)
+	return 0
+}
+
+// session holds the variables and the history shared by the lines
+type session struct {
+	vars        map[string]float64
+	history     []string
+	historyFile string
+	out, errOut io.Writer
+}
+
+// repl reads lines until :quit or the end of the input
+func (s *session) repl(stdin io.Reader) {
+	sc := bufio.NewScanner(stdin)
+	for {
+		fmt.Fprint(s.out, "input /> ")
+		if !sc.Scan() {
+			fmt.Fprintln(s.out)
+			return
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		s.record(line)
+		if quit, _ := s.line(line); quit {
+			fmt.Fprintln(s.out, "bye")
+			return
+		}
+	}
+}
+
+// line runs a line of input, a program or a command
+func (s *session) line(line string) (quit, ok bool) {
+	line = strings.TrimSpace(line)
+	cmd, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch {
+	case line == "":
+		return false, true
+	case line == ":quit" || line == ":q" || line == "exit" || line == "quit":
+		return true, true
+	case line == ":help":
+		fmt.Fprintln(s.out, help)
+	case line == ":vars":
+		names := make([]string, 0, len(s.vars))
+		for name := range s.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(s.out, "%s = %s\n", name, engine.Float64ToStr(s.vars[name]))
+		}
+	case line == ":history":
+		for i, h := range s.history {
+			fmt.Fprintf(s.out, "%4d  %s\n", i+1, h)
+		}
+	case cmd == ":ast" && arg == "":
+		fmt.Fprintln(s.errOut, "ERROR: :ast wants an expression, e.g. :ast 1 + 2")
+		return false, false
+	case cmd == ":ast":
+		e, err := engine.Compile(arg)
+		if err != nil {
+			fmt.Fprintln(s.errOut, "ERROR: "+err.Error())
+			return false, false
+		}
+		fmt.Fprintln(s.out, engine.ExprASTToString(e.Root))
+		printTree(s.out, e.Root, "", "")
+	case strings.HasPrefix(line, ":"):
+		fmt.Fprintf(s.errOut, "ERROR: unknown command '%s', :help lists the commands\n", cmd)
+		return false, false
+	default:
+		return false, s.exec(line)
+	}
+	return false, true
+}
+
+// exec evaluates the program line with the variables of the session and prints its result
+func (s *session) exec(line string) bool {
+	r, vars, err := engine.ExecProgram(line, s.vars)
+	if err != nil {
+		fmt.Fprintln(s.errOut, "ERROR: "+err.Error())
+		return false
+	}
+	s.vars = vars
+	s.vars["ans"] = r
+	fmt.Fprintln(s.out, engine.Float64ToStr(r))
+	return true
+}
+
+// printTree writes expr as an indented tree, first prefixes its own line
+// and rest the lines of its children
+func printTree(w io.Writer, expr engine.ExprAST, first, rest string) {
+	fmt.Fprintln(w, first+label(expr))
+	children := engine.Children(expr)
+	for i, c := range children {
+		if i == len(children)-1 {
+			printTree(w, c, rest+"`-- ", rest+"    ")
+		} else {
+			printTree(w, c, rest+"|-- ", rest+"|   ")
+		}
+	}
+}
+
+func label(expr engine.ExprAST) string {
+	switch e := expr.(type) {
+	case engine.NumberExprAST:
+		if e.Str != "" {
+			return e.Str
+		}
+		return engine.Float64ToStr(e.Val)
+	case engine.VariableExprAST:
+		return e.Name
+	case engine.BinaryExprAST:
+		return e.Op
+	case engine.UnaryExprAST:
+		if e.Postfix {
+			return "x" + e.Op
+		}
+		return e.Op
+	case engine.ConditionalExprAST:
+		return "? :"
+	case engine.FunCallerExprAST:
+		return e.Name + "()"
+	}
+	return fmt.Sprintf("%T", expr)
+}
+
+// historyFile is the path of the history of the REPL, "" to keep none
+func historyFile() string {
+	if f, ok := os.LookupEnv("MATHENGINE_HISTORY"); ok {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".mathengine_history")
+}
+
+func (s *session) loadHistory() {
+	if s.historyFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(s.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			s.history = append(s.history, line)
+		}
+	}
+}
+
+// record adds line to the history and appends it to the history file
+func (s *session) record(line string) {
+	s.history = append(s.history, line)
+	if s.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(s.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
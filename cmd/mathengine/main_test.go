@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	type U struct {
+		Args   []string
+		Stdin  string
+		Code   int
+		Stdout string
+	}
+	exprs := []U{
+		{[]string{"2*(3+4)"}, "", 0, "14\n"},
+		{[]string{"1", "+", "2"}, "", 0, "3\n"},
+		{[]string{"-3!"}, "", 0, "-6\n"},
+		{[]string{"1/0"}, "", 1, ""},
+		{nil, "1+2\n\nx = 3; x * 2\nx + ans\n", 0, "3\n6\n9\n"},
+		{nil, "1+\n2\n", 1, "2\n"},
+		{nil, ":ast 1 + -x\n", 0, "(1 + (-x))\n+\n|-- 1\n`-- -\n    `-- x\n"},
+		{nil, ":nope\n", 1, ""},
+		{nil, ":ast\n", 1, ""},
+	}
+	for _, e := range exprs {
+		var stdout, stderr bytes.Buffer
+		code := run(e.Args, strings.NewReader(e.Stdin), &stdout, &stderr, false)
+		if code != e.Code || stdout.String() != e.Stdout {
+			t.Errorf("%v %q: exit code %d, stdout %q, stderr %q", e.Args, e.Stdin, code, stdout.String(), stderr.String())
+		}
+		if (code != 0) != (stderr.Len() > 0) {
+			t.Errorf("%v %q: exit code %d, stderr %q", e.Args, e.Stdin, code, stderr.String())
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mathengine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	history := filepath.Join(dir, "history")
+	old, set := os.LookupEnv("MATHENGINE_HISTORY")
+	os.Setenv("MATHENGINE_HISTORY", history)
+	defer func() {
+		if set {
+			os.Setenv("MATHENGINE_HISTORY", old)
+		} else {
+			os.Unsetenv("MATHENGINE_HISTORY")
+		}
+	}()
+
+	var stdout, stderr bytes.Buffer
+	in := "a = 2\nb = a ** 3\n1/0\n:vars\n:quit\nc = 1\n"
+	if code := run(nil, strings.NewReader(in), &stdout, &stderr, true); code != 0 {
+		t.Error("REPL exit code ", code)
+	}
+	want := "input /> 2\ninput /> 8\ninput /> input /> a = 2\nans = 8\nb = 8\ninput /> bye\n"
+	if stdout.String() != want {
+		t.Errorf("REPL stdout %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "division by zero") {
+		t.Errorf("REPL stderr %q", stderr.String())
+	}
+
+	stdout.Reset()
+	run(nil, strings.NewReader(":history\n"), &stdout, &stderr, true)
+	if !strings.Contains(stdout.String(), "   1  a = 2\n") || !strings.Contains(stdout.String(), "   6  :history\n") {
+		t.Errorf("REPL history %q", stdout.String())
+	}
+}
@@ -1,19 +1,40 @@
 package engine
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
 	"strconv"
+	"strings"
 )
 
-var precedence = map[string]int{"+": 90, "-": 90, "*": 100, "/": 100, "%": 100, ">": 70, "&": 60, "<": 70, ">>": 80, "<<": 80, "|": 40, "^": 50}
+var precedence = map[string]int{
+	"**": 110, "+": 90, "-": 90, "*": 100, "/": 100, "%": 100,
+	">": 70, "<": 70, ">=": 70, "<=": 70, "==": 70, "!=": 70,
+	"&": 60, ">>": 80, "<<": 80, "|": 40, "^": 50, "&&": 30, "||": 20, "?:": 10,
+}
+
+// rightAssoc are the right-associative operators, "2 ** 3 ** 2" is "2 ** (3 ** 2)"
+var rightAssoc = map[string]bool{"**": true}
+
+// prefixOps are the prefix unary operators, they bind looser than "**" and
+// tighter than the other binary operators: "-2 ** 2" is "-(2 ** 2)", "-2 * 3" is "(-2) * 3".
+// the postfix factorial "!" binds tightest, "-3!" is "-(3!)", like the percentage "%"
+// of Config.PercentLiterals
+var prefixOps = map[string]bool{"-": true, "+": true, "~": true, "!": true}
+
+// comparisonOps return 1 or 0
+var comparisonOps = map[string]bool{">": true, "<": true, ">=": true, "<=": true, "==": true, "!=": true}
 
 type ExprAST interface {
 	toStr() string
 }
 
 type NumberExprAST struct {
-	Val int
+	Val float64
 	Str string
 }
 
@@ -23,6 +44,29 @@ type BinaryExprAST struct {
 	Rhs ExprAST
 }
 
+// UnaryExprAST is the prefix operator Op, one of "-" "+" "~" "!", applied to Operand,
+// or the postfix one if Postfix is set: the factorial "!" or the percentage "%",
+// which is Operand / 100, or a percentage of the left side of '+' and '-'
+type UnaryExprAST struct {
+	Op      string
+	Operand ExprAST
+	Postfix bool
+}
+
+type VariableExprAST struct {
+	Name string
+	// of the name in the source
+	Offset int
+}
+
+// ConditionalExprAST is "Cond ? Then : Else",
+// only one of Then and Else is evaluated
+type ConditionalExprAST struct {
+	Cond,
+	Then,
+	Else ExprAST
+}
+
 type FunCallerExprAST struct {
 	Name string
 	Arg  []ExprAST
@@ -44,6 +88,37 @@ func (b BinaryExprAST) toStr() string {
 	)
 }
 
+func (u UnaryExprAST) toStr() string {
+	if u.Postfix {
+		return fmt.Sprintf(
+			"UnaryExprAST: (%s %s)",
+			u.Operand.toStr(),
+			u.Op,
+		)
+	}
+	return fmt.Sprintf(
+		"UnaryExprAST: (%s %s)",
+		u.Op,
+		u.Operand.toStr(),
+	)
+}
+
+func (v VariableExprAST) toStr() string {
+	return fmt.Sprintf(
+		"VariableExprAST:%s",
+		v.Name,
+	)
+}
+
+func (c ConditionalExprAST) toStr() string {
+	return fmt.Sprintf(
+		"ConditionalExprAST: (%s ? %s : %s)",
+		c.Cond.toStr(),
+		c.Then.toStr(),
+		c.Else.toStr(),
+	)
+}
+
 func (n FunCallerExprAST) toStr() string {
 	return fmt.Sprintf(
 		"FunCallerExprAST:%s",
@@ -51,6 +126,170 @@ func (n FunCallerExprAST) toStr() string {
 	)
 }
 
+// Children returns the direct sub-expressions of expr in order,
+// Lhs and Rhs for BinaryExprAST, Operand for UnaryExprAST, Cond, Then and Else for ConditionalExprAST
+// the arguments for FunCallerExprAST and the Value for AssignExprAST
+func Children(expr ExprAST) []ExprAST {
+	switch e := expr.(type) {
+	case BinaryExprAST:
+		return []ExprAST{e.Lhs, e.Rhs}
+	case UnaryExprAST:
+		return []ExprAST{e.Operand}
+	case ConditionalExprAST:
+		return []ExprAST{e.Cond, e.Then, e.Else}
+	case FunCallerExprAST:
+		return e.Arg
+	case AssignExprAST:
+		return []ExprAST{e.Value}
+	}
+	return nil
+}
+
+// ToDOT is a Top level function
+// formats the AST as a Graphviz DOT graph,
+// operator nodes are labeled by their op and leaf nodes by their value
+func ToDOT(expr ExprAST) string {
+	var b strings.Builder
+	b.WriteString("digraph ExprAST {\n")
+	id := 0
+	var node func(expr ExprAST) int
+	node = func(expr ExprAST) int {
+		n := id
+		id++
+		switch e := expr.(type) {
+		case BinaryExprAST:
+			fmt.Fprintf(&b, "\tn%d [label=%q];\n", n, e.Op)
+			for _, c := range []ExprAST{e.Lhs, e.Rhs} {
+				fmt.Fprintf(&b, "\tn%d -> n%d;\n", n, node(c))
+			}
+		case UnaryExprAST:
+			label := e.Op
+			if e.Postfix {
+				label = "x" + e.Op
+			}
+			fmt.Fprintf(&b, "\tn%d [label=%q];\n", n, label)
+			fmt.Fprintf(&b, "\tn%d -> n%d;\n", n, node(e.Operand))
+		case ConditionalExprAST:
+			fmt.Fprintf(&b, "\tn%d [label=%q];\n", n, "? :")
+			for _, c := range []ExprAST{e.Cond, e.Then, e.Else} {
+				fmt.Fprintf(&b, "\tn%d -> n%d;\n", n, node(c))
+			}
+		case FunCallerExprAST:
+			fmt.Fprintf(&b, "\tn%d [label=%q];\n", n, e.Name+"()")
+			for _, c := range e.Arg {
+				fmt.Fprintf(&b, "\tn%d -> n%d;\n", n, node(c))
+			}
+		case NumberExprAST:
+			fmt.Fprintf(&b, "\tn%d [label=%q];\n", n, Float64ToStr(e.Val))
+		case VariableExprAST:
+			fmt.Fprintf(&b, "\tn%d [label=%q];\n", n, e.Name)
+		}
+		return n
+	}
+	if expr != nil {
+		node(expr)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ExprASTToString is a Top level function
+// formats the AST as a fully parenthesized expression that parses back to it,
+// e.g. "1+2*3" is "(1 + (2 * 3))" and "-x" is "(-x)"
+func ExprASTToString(expr ExprAST) string {
+	switch e := expr.(type) {
+	case BinaryExprAST:
+		lhs := ExprASTToString(e.Lhs)
+		if e.Op == "**" && isNegativeNumber(e.Lhs) {
+			lhs = "(" + lhs + ")"
+		}
+		return "(" + lhs + " " + e.Op + " " + ExprASTToString(e.Rhs) + ")"
+	case UnaryExprAST:
+		operand := ExprASTToString(e.Operand)
+		if e.Postfix {
+			if isNegativeNumber(e.Operand) {
+				operand = "(" + operand + ")"
+			}
+			return "(" + operand + e.Op + ")"
+		}
+		return "(" + e.Op + operand + ")"
+	case ConditionalExprAST:
+		return "(" + ExprASTToString(e.Cond) + " ? " + ExprASTToString(e.Then) + " : " + ExprASTToString(e.Else) + ")"
+	case FunCallerExprAST:
+		args := make([]string, len(e.Arg))
+		for i, a := range e.Arg {
+			args[i] = ExprASTToString(a)
+		}
+		return e.Name + "(" + strings.Join(args, ", ") + ")"
+	case VariableExprAST:
+		return e.Name
+	case AssignExprAST:
+		return e.Name + " = " + ExprASTToString(e.Value)
+	case NumberExprAST:
+		if e.Str != "" {
+			return e.Str
+		}
+		return Float64ToStr(e.Val)
+	}
+	return ""
+}
+
+// HashExpr is a Top level function
+// returns a stable hash of the structure of the AST,
+// source positions and spacing don't change it but operand order does
+func HashExpr(expr ExprAST) uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, binary.MaxVarintLen64)
+	writeInt := func(i int64) {
+		h.Write(buf[:binary.PutVarint(buf, i)])
+	}
+	writeStr := func(s string) {
+		writeInt(int64(len(s)))
+		h.Write([]byte(s))
+	}
+	var node func(expr ExprAST)
+	node = func(expr ExprAST) {
+		switch e := expr.(type) {
+		case BinaryExprAST:
+			h.Write([]byte{'B'})
+			writeStr(e.Op)
+			node(e.Lhs)
+			node(e.Rhs)
+		case UnaryExprAST:
+			h.Write([]byte{'U'})
+			writeStr(e.Op)
+			if e.Postfix {
+				h.Write([]byte{1})
+			} else {
+				h.Write([]byte{0})
+			}
+			node(e.Operand)
+		case ConditionalExprAST:
+			h.Write([]byte{'C'})
+			node(e.Cond)
+			node(e.Then)
+			node(e.Else)
+		case FunCallerExprAST:
+			h.Write([]byte{'F'})
+			writeStr(e.Name)
+			writeInt(int64(len(e.Arg)))
+			for _, a := range e.Arg {
+				node(a)
+			}
+		case NumberExprAST:
+			h.Write([]byte{'N'})
+			writeInt(int64(math.Float64bits(e.Val)))
+		case VariableExprAST:
+			h.Write([]byte{'V'})
+			writeStr(e.Name)
+		default:
+			h.Write([]byte{0})
+		}
+	}
+	node(expr)
+	return h.Sum64()
+}
+
 type AST struct {
 	Tokens []*Token
 
@@ -58,14 +297,48 @@ type AST struct {
 	currTok   *Token
 	currIndex int
 	depth     int
+	cfg       Config
+
+	// nesting is the recursion depth checked against Config.MaxDepth,
+	// nodes the number of nodes built checked against Config.MaxNodes
+	nesting int
+	nodes   int
+
+	// Trace, if set, logs the decisions of the parser: each ParseExpression
+	// and parseBinOpRHS recursion, each token consumed and each BinaryExprAST built
+	Trace      io.Writer
+	traceDepth int
+
+	// onBinary, if set, is called for each BinaryExprAST built with the offset
+	// of its operator and the operators of its operands that are not parenthesized
+	onBinary func(op string, offset int, lhsOp, rhsOp string)
+
+	// collect, set by Validate, records the undefined functions and
+	// the wrong numbers of arguments in errs instead of stopping at them
+	collect bool
+	errs    []error
+
+	// uintLiterals, set by ParseAndExecInt, accepts 0x 0o 0b literals up to
+	// the uint64 range
+	uintLiterals bool
+
+	// exactLiterals, set by ParseAndExecBig and ParseAndExecRat, accepts the
+	// literals out of the float64 and int64 range, they evaluate Str exactly
+	exactLiterals bool
 
 	Err error
 }
 
 func NewAST(toks []*Token, s string) *AST {
+	return NewASTWithConfig(toks, s, Config{})
+}
+
+// NewASTWithConfig is like NewAST, with the parser options set in cfg
+func NewASTWithConfig(toks []*Token, s string, cfg Config) *AST {
 	a := &AST{
 		Tokens: toks,
 		source: s,
+		cfg:    cfg,
 	}
 	if a.Tokens == nil || len(a.Tokens) == 0 {
 		a.Err = errors.New("empty token")
@@ -76,20 +349,131 @@ func NewAST(toks []*Token, s string) *AST {
 	return a
 }
 
+// parseError is a ParseError at offset of the source, on the token there
+func (a *AST) parseError(kind ErrorKind, offset int, msg string) *ParseError {
+	token := ""
+	for _, tok := range a.Tokens {
+		if tok.Offset == offset {
+			token = tok.Tok
+			break
+		}
+	}
+	return newParseError(kind, a.source, offset, token, msg)
+}
+
+// enter counts a nested parse against Config.MaxDepth, it is false past the limit
+func (a *AST) enter() bool {
+	a.nesting++
+	if max := a.cfg.maxDepth(); a.nesting > max {
+		if a.Err == nil {
+			a.Err = a.parseError(ErrLimit, a.currTok.Offset,
+				fmt.Sprintf("parse limit exceeded: nested deeper than %d", max))
+		}
+		return false
+	}
+	return true
+}
+
+func (a *AST) leave() {
+	a.nesting--
+}
+
+// addNode counts n nodes built against Config.MaxNodes, it is false past the limit
+func (a *AST) addNode(n int) bool {
+	a.nodes += n
+	if max := a.cfg.MaxNodes; max > 0 && a.nodes > max {
+		if a.Err == nil {
+			a.Err = a.parseError(ErrLimit, a.currTok.Offset,
+				fmt.Sprintf("parse limit exceeded: more than %d nodes", max))
+		}
+		return false
+	}
+	return true
+}
+
+// literalErrorKind is ErrOverflow for a literal out of range, else ErrSyntax
+func literalErrorKind(err error) ErrorKind {
+	if errors.Is(err, strconv.ErrRange) {
+		return ErrOverflow
+	}
+	return ErrSyntax
+}
+
 func (a *AST) ParseExpression() ExprAST {
 	a.depth++ // called depth
+	if a.Trace != nil {
+		a.trace("ParseExpression")
+		a.traceDepth++
+	}
 	lhs := a.parsePrimary()
 	r := a.parseBinOpRHS(0, lhs)
+	if r != nil && a.Err == nil && a.currIndex < len(a.Tokens) && a.currTok.Tok == "?" {
+		r = a.parseConditional(r)
+	}
+	if a.Trace != nil {
+		a.traceDepth--
+	}
 	a.depth--
 	if a.depth == 0 && a.currIndex != len(a.Tokens) && a.Err == nil {
-		a.Err = errors.New(
-			fmt.Sprintf("bad expression, reaching the end or missing the operator\n%s",
-				ErrPos(a.source, a.currTok.Offset)))
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "bad expression, reaching the end or missing the operator")
 	}
 	return r
 }
 
+// parseConditional parses "? Then : Else" after cond, it binds looser than
+// every binary operator and is right-associative
+func (a *AST) parseConditional(cond ExprAST) ExprAST {
+	// the branches nest through ParseExpression
+	if !a.enter() {
+		return nil
+	}
+	defer a.leave()
+	if a.getNextToken() == nil {
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "want '(' or '0-9' but get EOF")
+		return nil
+	}
+	then := a.ParseExpression()
+	if a.Err != nil {
+		return nil
+	}
+	if a.currIndex == len(a.Tokens) {
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "want ':' but get EOF")
+		return nil
+	}
+	if a.currTok.Tok != ":" {
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset, fmt.Sprintf("want ':' but get %s", a.currTok.Tok))
+		return nil
+	}
+	if a.getNextToken() == nil {
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "want '(' or '0-9' but get EOF")
+		return nil
+	}
+	els := a.ParseExpression()
+	if a.Err != nil {
+		return nil
+	}
+	if !a.addNode(1) {
+		return nil
+	}
+	c := ConditionalExprAST{
+		Cond: cond,
+		Then: then,
+		Else: els,
+	}
+	if a.Trace != nil {
+		a.trace("build %s", c.toStr())
+	}
+	return c
+}
+
+func (a *AST) trace(format string, args ...interface{}) {
+	fmt.Fprintf(a.Trace, "%s%s\n", strings.Repeat("  ", a.traceDepth), fmt.Sprintf(format, args...))
+}
+
 func (a *AST) getNextToken() *Token {
+	if a.Trace != nil && a.currTok != nil {
+		a.trace("consume '%s'", a.currTok.Tok)
+	}
 	a.currIndex++
 	if a.currIndex < len(a.Tokens) {
 		a.currTok = a.Tokens[a.currIndex]
@@ -99,20 +483,110 @@ func (a *AST) getNextToken() *Token {
 }
 
 func (a *AST) getTokPrecedence() int {
+	if a.currTok.Flag == FlagPercent {
+		// a postfix percentage, not the modulo
+		return -1
+	}
 	if p, ok := precedence[a.currTok.Tok]; ok {
 		return p
 	}
 	return -1
 }
 
+// isRadixLiteral reports whether tok is a 0x, 0o or 0b integer literal
+func isRadixLiteral(tok string) bool {
+	return len(tok) > 1 && tok[0] == '0' && strings.IndexByte("xXoObB", tok[1]) >= 0
+}
+
+// literalError describes why the literal tok doesn't parse, err is the strconv error
+func literalError(tok string, err error) string {
+	if isRadixLiteral(tok) {
+		base, name := 16, "a hexadecimal"
+		switch tok[1] {
+		case 'o', 'O':
+			base, name = 8, "an octal"
+		case 'b', 'B':
+			base, name = 2, "a binary"
+		}
+		switch {
+		case errors.Is(err, strconv.ErrRange):
+			return fmt.Sprintf("invalid integer literal '%s': it overflows int64", tok)
+		case len(tok) == 2:
+			return fmt.Sprintf("invalid integer literal '%s': no digits after '%s'", tok, tok)
+		}
+		for _, c := range tok[2:] {
+			if _, err := strconv.ParseInt(string(c), base, 64); err != nil {
+				return fmt.Sprintf("invalid integer literal '%s': '%c' is not %s digit", tok, c, name)
+			}
+		}
+		return fmt.Sprintf("invalid integer literal '%s'", tok)
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return fmt.Sprintf("invalid number literal '%s': it overflows float64", tok)
+	}
+	mantissa, exp := tok, ""
+	if i := strings.IndexAny(tok, "eE"); i >= 0 {
+		mantissa, exp = tok[:i], strings.TrimLeft(tok[i+1:], "+-")
+		switch {
+		case exp == "":
+			return fmt.Sprintf("invalid number literal '%s': the exponent has no digits", tok)
+		case strings.ContainsAny(exp, ".eE+-"):
+			return fmt.Sprintf("invalid number literal '%s': the exponent must be an integer", tok)
+		}
+	}
+	if strings.Count(mantissa, ".") > 1 {
+		return fmt.Sprintf("invalid number literal '%s': more than one decimal point", tok)
+	}
+	return fmt.Sprintf("invalid number literal '%s'", tok)
+}
+
 func (a *AST) parseNumber() NumberExprAST {
-	f64, err := strconv.Atoi(a.currTok.Tok)
+	if isRadixLiteral(a.currTok.Tok) {
+		i64, err := strconv.ParseInt(a.currTok.Tok, 0, 64)
+		if err != nil && a.uintLiterals && errors.Is(err, strconv.ErrRange) {
+			var u64 uint64
+			if u64, err = strconv.ParseUint(a.currTok.Tok, 0, 64); err == nil {
+				n := NumberExprAST{
+					Val: float64(u64),
+					Str: a.currTok.Tok,
+				}
+				a.getNextToken()
+				return n
+			}
+		}
+		if err != nil && a.exactLiterals && errors.Is(err, strconv.ErrRange) {
+			// Val is only the float64 approximation
+			i64, err = math.MaxInt64, nil
+		}
+		if err != nil {
+			a.Err = a.parseError(literalErrorKind(err), a.currTok.Offset, literalError(a.currTok.Tok, err))
+			return NumberExprAST{}
+		}
+		n := NumberExprAST{
+			Val: float64(i64),
+			Str: a.currTok.Tok,
+		}
+		a.getNextToken()
+		return n
+	}
+	f64, err := strconv.ParseFloat(a.currTok.Tok, 64)
+	if err != nil && a.cfg.SaturateLiterals && errors.Is(err, strconv.ErrRange) && math.IsInf(f64, 1) {
+		// clamp, a negated literal becomes the smallest float64
+		f64, err = math.MaxFloat64, nil
+	}
+	if err != nil && a.exactLiterals && errors.Is(err, strconv.ErrRange) {
+		// f64 is ±Inf or 0, Str keeps the exact value
+		err = nil
+	}
+	if err != nil && a.currTok.Type == Literal {
+		a.Err = a.parseError(literalErrorKind(err), a.currTok.Offset, literalError(a.currTok.Tok, err))
+		return NumberExprAST{}
+	}
 	if err != nil {
-		a.Err = errors.New(
-			fmt.Sprintf("%v\nwant '(' or '0-9' but get '%s'\n%s",
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset,
+			fmt.Sprintf("%v\nwant '(' or '0-9' but get '%s'",
 				err.Error(),
-				a.currTok.Tok,
-				ErrPos(a.source, a.currTok.Offset)))
+				a.currTok.Tok))
 		return NumberExprAST{}
 	}
 	n := NumberExprAST{
@@ -123,17 +597,117 @@ func (a *AST) parseNumber() NumberExprAST {
 	return n
 }
 
+func (a *AST) parseFunCaller() ExprAST {
+	name := a.currTok.Tok
+	offset := a.currTok.Offset
+	a.getNextToken() // '('
+	def, _, ok := lookupFunc(name)
+	if !ok {
+		err := a.parseError(ErrUnknownIdentifier, offset,
+			fmt.Sprintf("function '%s' is undefined%s", name, didYouMean(name, funcNames())))
+		if !a.collect {
+			a.Err = err
+			return nil
+		}
+		// Validate, go on with the arguments
+		a.errs = append(a.errs, err)
+	}
+	f := FunCallerExprAST{Name: name}
+	if a.getNextToken() == nil {
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "want ')' but get EOF")
+		return nil
+	}
+	for i := 0; i > 0 || a.currTok.Tok != ")"; i++ {
+		// an argument follows each ','
+		e := a.ParseExpression()
+		if a.Err != nil {
+			return nil
+		}
+		f.Arg = append(f.Arg, e)
+		if a.currIndex == len(a.Tokens) {
+			a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "want ')' but get EOF")
+			return nil
+		}
+		if a.currTok.Tok == ")" {
+			break
+		}
+		if a.currTok.Type != COMMA {
+			a.Err = a.parseError(ErrSyntax, a.currTok.Offset, fmt.Sprintf("want ',' or ')' but get %s", a.currTok.Tok))
+			return nil
+		}
+		if a.getNextToken() == nil {
+			a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "want '(' or '0-9' but get EOF")
+			return nil
+		}
+	}
+	if err := checkArgc(name, def, len(f.Arg)); ok && err != nil {
+		if !a.collect {
+			a.Err = a.parseError(ErrSyntax, offset, err.Error())
+			return nil
+		}
+		a.errs = append(a.errs, a.parseError(ErrSyntax, offset, err.Error()))
+	}
+	a.getNextToken()
+	return f
+}
+
 func (a *AST) parsePrimary() ExprAST {
+	if !a.enter() {
+		return nil
+	}
+	defer a.leave()
+	if a.currTok.Type == Operator && prefixOps[a.currTok.Tok] {
+		return a.parseUnary()
+	}
+	e := a.parseOperand()
+	for e != nil && a.Err == nil && a.currIndex < len(a.Tokens) &&
+		(a.currTok.Tok == "!" || a.currTok.Tok == "%" && a.currTok.Flag == FlagPercent) {
+		// postfix factorial or percentage
+		if !a.addNode(1) {
+			return nil
+		}
+		e = UnaryExprAST{Op: a.currTok.Tok, Operand: e, Postfix: true}
+		a.getNextToken()
+	}
+	return e
+}
+
+// parseUnary parses a prefix operator, its operand is a primary
+// with the "**" operators that follow it
+func (a *AST) parseUnary() ExprAST {
+	op := a.currTok.Tok
+	if !a.addNode(1) {
+		return nil
+	}
+	if a.getNextToken() == nil {
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset, fmt.Sprintf("want '0-9' but get '%s'", op))
+		return nil
+	}
+	operand := a.parsePrimary()
+	if operand == nil || a.Err != nil {
+		return nil
+	}
+	if pow := precedence["**"]; a.currIndex < len(a.Tokens) && a.getTokPrecedence() >= pow {
+		if operand = a.parseBinOpRHS(pow, operand); operand == nil {
+			return nil
+		}
+	}
+	return UnaryExprAST{Op: op, Operand: operand}
+}
+
+// parseOperand parses a number, a parenthesized expression, a variable or a function call
+func (a *AST) parseOperand() ExprAST {
 	switch a.currTok.Type {
 	case Literal:
+		if !a.addNode(1) {
+			return nil
+		}
 		return a.parseNumber()
 	case Operator:
 		if a.currTok.Tok == "(" {
 			t := a.getNextToken()
 			if t == nil {
-				a.Err = errors.New(
-					fmt.Sprintf("want '(' or '0-9' but get EOF\n%s",
-						ErrPos(a.source, a.currTok.Offset)))
+				a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "want '(' or '0-9' but get EOF")
 				return nil
 			}
 			e := a.ParseExpression()
@@ -141,35 +715,26 @@ func (a *AST) parsePrimary() ExprAST {
 				return nil
 			}
 			if a.currTok.Tok != ")" {
-				a.Err = errors.New(
-					fmt.Sprintf("want ')' but get %s\n%s",
-						a.currTok.Tok,
-						ErrPos(a.source, a.currTok.Offset)))
+				a.Err = a.parseError(ErrSyntax, a.currTok.Offset, fmt.Sprintf("want ')' but get %s", a.currTok.Tok))
 				return nil
 			}
 			a.getNextToken()
 			return e
-		} else if a.currTok.Tok == "-" {
-			if a.getNextToken() == nil {
-				a.Err = errors.New(
-					fmt.Sprintf("want '0-9' but get '-'\n%s",
-						ErrPos(a.source, a.currTok.Offset)))
-				return nil
-			}
-			bin := BinaryExprAST{
-				Op:  "-",
-				Lhs: NumberExprAST{},
-				Rhs: a.parsePrimary(),
-			}
-			return bin
 		} else {
 			return a.parseNumber()
 		}
+	case Identifier:
+		if !a.addNode(1) {
+			return nil
+		}
+		if a.currIndex+1 < len(a.Tokens) && a.Tokens[a.currIndex+1].Tok == "(" {
+			return a.parseFunCaller()
+		}
+		v := VariableExprAST{Name: a.currTok.Tok, Offset: a.currTok.Offset}
+		a.getNextToken()
+		return v
 	case COMMA:
-		a.Err = errors.New(
-			fmt.Sprintf("want '(' or '0-9' but get %s\n%s",
-				a.currTok.Tok,
-				ErrPos(a.source, a.currTok.Offset)))
+		a.Err = a.parseError(ErrSyntax, a.currTok.Offset, fmt.Sprintf("want '(' or '0-9' but get %s", a.currTok.Tok))
 		return nil
 	default:
 		return nil
@@ -177,33 +742,70 @@ func (a *AST) parsePrimary() ExprAST {
 }
 
 func (a *AST) parseBinOpRHS(execPrec int, lhs ExprAST) ExprAST {
+	// operator of lhs if it was built in this loop, not parenthesized
+	lhsOp := ""
 	for {
 		tokPrec := a.getTokPrecedence()
 		if tokPrec < execPrec {
 			return lhs
 		}
 		binOp := a.currTok.Tok
+		opOffset := a.currTok.Offset
+		if comparisonOps[binOp] && comparisonOps[lhsOp] && a.cfg.ForbidChainedComparisons {
+			a.Err = a.parseError(ErrSyntax, a.currTok.Offset,
+				"comparison operators cannot be chained; use parentheses or combine them with '&&'")
+			return nil
+		}
 		if a.getNextToken() == nil {
-			a.Err = errors.New(
-				fmt.Sprintf("want '(' or '0-9' but get EOF\n%s",
-					ErrPos(a.source, a.currTok.Offset)))
+			a.Err = a.parseError(ErrSyntax, a.currTok.Offset, "want '(' or '0-9' but get EOF")
 			return nil
 		}
 		rhs := a.parsePrimary()
 		if rhs == nil {
 			return nil
 		}
+		rhsOp := ""
 		nextPrec := a.getTokPrecedence()
-		if tokPrec < nextPrec {
-			rhs = a.parseBinOpRHS(tokPrec+1, rhs)
+		// rhs takes the operators that bind tighter, and those of the same
+		// precedence for a right-associative operator
+		rhsPrec := tokPrec + 1
+		if rightAssoc[binOp] {
+			rhsPrec = tokPrec
+		}
+		if rhsPrec <= nextPrec {
+			if a.Trace != nil {
+				a.trace("parseBinOpRHS execPrec %d", rhsPrec)
+				a.traceDepth++
+			}
+			if !a.enter() {
+				return nil
+			}
+			rhs = a.parseBinOpRHS(rhsPrec, rhs)
+			a.leave()
+			if a.Trace != nil {
+				a.traceDepth--
+			}
 			if rhs == nil {
 				return nil
 			}
+			if b, ok := rhs.(BinaryExprAST); ok {
+				rhsOp = b.Op
+			}
+		}
+		if !a.addNode(1) {
+			return nil
 		}
 		lhs = BinaryExprAST{
 			Op:  binOp,
 			Lhs: lhs,
 			Rhs: rhs,
 		}
+		if a.onBinary != nil {
+			a.onBinary(binOp, opOffset, lhsOp, rhsOp)
+		}
+		lhsOp = binOp
+		if a.Trace != nil {
+			a.trace("build %s", lhs.toStr())
+		}
 	}
 }
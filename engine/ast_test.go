@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func parseExpr(t *testing.T, s string) ExprAST {
+	toks, err := Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast := NewAST(toks, s)
+	if ast.Err != nil {
+		t.Fatal(ast.Err)
+	}
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		t.Fatal(ast.Err)
+	}
+	return ar
+}
+
+func TestToDOT(t *testing.T) {
+	exp := "1 + 2 * 3"
+	ar := parseExpr(t, exp)
+	want := `digraph ExprAST {
+	n0 [label="+"];
+	n1 [label="1"];
+	n0 -> n1;
+	n2 [label="*"];
+	n3 [label="2"];
+	n2 -> n3;
+	n4 [label="3"];
+	n2 -> n4;
+	n0 -> n2;
+}
+`
+	if r := ToDOT(ar); r != want {
+		t.Error(exp, " ToDOT:\n", r)
+	}
+}
+
+func TestHashExpr(t *testing.T) {
+	equal := [][2]string{
+		{"1+2", "1 + 2"},
+		{"(1+2)*3", " ( 1 + 2 ) * 3 "},
+		{"1_000 - 1", "1000-1"},
+	}
+	for _, e := range equal {
+		if HashExpr(parseExpr(t, e[0])) != HashExpr(parseExpr(t, e[1])) {
+			t.Error(e, " HashExpr should be equal")
+		}
+	}
+	differ := [][2]string{
+		{"1+2", "2+1"},
+		{"1-2", "1+2"},
+		{"(1+2)*3", "1+2*3"},
+		{"12+3", "1+23"},
+	}
+	for _, e := range differ {
+		if HashExpr(parseExpr(t, e[0])) == HashExpr(parseExpr(t, e[1])) {
+			t.Error(e, " HashExpr should differ")
+		}
+	}
+}
+
+func TestChildren(t *testing.T) {
+	ar := parseExpr(t, "1 + 2 * 3")
+	c := Children(ar)
+	if len(c) != 2 || c[0] != (NumberExprAST{Val: 1, Str: "1"}) {
+		t.Error("Children of 1 + 2 * 3: ", c)
+	}
+	if c := Children(NumberExprAST{Val: 1}); len(c) != 0 {
+		t.Error("NumberExprAST should have no children: ", c)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	exp := "1 + 2 * 3"
+	toks, _ := Parse(exp)
+	ast := NewAST(toks, exp)
+	var b bytes.Buffer
+	ast.Trace = &b
+	ast.ParseExpression()
+	if ast.Err != nil {
+		t.Fatal(ast.Err)
+	}
+	want := `ParseExpression
+  consume '1'
+  consume '+'
+  consume '2'
+  parseBinOpRHS execPrec 91
+    consume '*'
+    consume '3'
+    build BinaryExprAST: (* NumberExprAST:2 NumberExprAST:3)
+  build BinaryExprAST: (+ NumberExprAST:1 BinaryExprAST: (* NumberExprAST:2 NumberExprAST:3))
+`
+	if b.String() != want {
+		t.Error(exp, " Trace:\n", b.String())
+	}
+}
+
+func TestExprASTToString(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		Want string
+	}{
+		{"1+2*3", "(1 + (2 * 3))"},
+		{"(1+2)*3", "((1 + 2) * 3)"},
+		{"1-2-3", "((1 - 2) - 3)"},
+		{"-1", "(-1)"},
+		{"--x", "(-(-x))"},
+		{"2 * -(3 + y)", "(2 * (-(3 + y)))"},
+		{"~1 & 3", "((~1) & 3)"},
+		{"max(1, 2 + 3, sqrt(4))", "max(1, (2 + 3), sqrt(4))"},
+		{"0xFF | 1e3 % 2", "(0xFF | (1e3 % 2))"},
+		{"1 < 2 == 3 >= 4 ?: 5", "((((1 < 2) == 3) >= 4) ?: 5)"},
+		{"1 << 2 >> 3 ^ 4 | 5 != 6 <= 7 > 8", "((((1 << 2) >> 3) ^ 4) | (((5 != 6) <= 7) > 8))"},
+		{"0 - 1", "(0 - 1)"},
+		{"x > 1 ? -x : y ? 1 : 2", "((x > 1) ? (-x) : (y ? 1 : 2))"},
+	}
+	for _, e := range exprs {
+		ar := parseExpr(t, e.Expr)
+		s := ExprASTToString(ar)
+		if s != e.Want {
+			t.Error(e, " ExprASTToString:", s)
+		}
+		// the output parses back to the same AST
+		if HashExpr(parseExpr(t, s)) != HashExpr(ar) {
+			t.Error(e, " ExprASTToString doesn't round trip:", s)
+		}
+	}
+	if s := ExprASTToString(BinaryExprAST{"-", NumberExprAST{Val: 2}, NumberExprAST{Val: -1}}); s != "(2 - -1)" {
+		t.Error(" ExprASTToString built AST:", s)
+	}
+}
+
+// nameCollector is a Visitor collecting variable names, it doesn't descend into calls
+type nameCollector struct {
+	names []string
+	nodes int
+}
+
+func (c *nameCollector) Visit(expr ExprAST) Visitor {
+	if expr == nil {
+		return nil
+	}
+	c.nodes++
+	switch e := expr.(type) {
+	case VariableExprAST:
+		c.names = append(c.names, e.Name)
+	case FunCallerExprAST:
+		return nil
+	}
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	c := &nameCollector{}
+	Walk(parseExpr(t, "a * (b + 1) > 0 ? max(c, 2) : d"), c)
+	if !reflect.DeepEqual(c.names, []string{"a", "b", "d"}) || c.nodes != 10 {
+		t.Error(c.names, c.nodes, " Walk")
+	}
+}
+
+func TestEncodeAST(t *testing.T) {
+	exprs := []string{
+		"1",
+		"x + 1",
+		"-0x10 * y ** 2",
+		"a > 0 ? max(a, 2.50, 3) : ~b",
+		"!(x == 1) && y ?: 3",
+		"noerr(1 / 0) + pi",
+		"-x! + +2",
+	}
+	for _, s := range exprs {
+		expr := parseExpr(t, s)
+		data, err := EncodeAST(expr)
+		if err != nil {
+			t.Fatal(err, s, " EncodeAST")
+		}
+		back, err := DecodeAST(data)
+		if err != nil || !reflect.DeepEqual(back, expr) {
+			t.Error(err, s, " DecodeAST:", string(data))
+		}
+	}
+
+	data, _ := EncodeAST(parseExpr(t, "2 * x"))
+	if string(data) != `{"type":"binary","op":"*","lhs":{"type":"number","value":2,"str":"2"},"rhs":{"type":"variable","name":"x","offset":4}}` {
+		t.Error(string(data), " EncodeAST")
+	}
+	expr, _ := DecodeAST(data)
+	if r, err := ParseAndExecWith("2 * x", map[string]float64{"x": 4}); err != nil || r != ExprASTResultWith(expr, map[string]float64{"x": 4}) {
+		t.Error(err, " decoded AST evaluation:", r)
+	}
+
+	for _, s := range []string{
+		`{"type":"binary","op":"+","lhs":{"type":"number","value":1}}`,
+		`{"type":"unknown"}`,
+		`{"type":"call","name":"max","args":[null]}`,
+		`[1, 2]`,
+	} {
+		if _, err := DecodeAST([]byte(s)); err == nil {
+			t.Error(s, " DecodeAST should fail")
+		}
+	}
+	if _, err := EncodeAST(NumberExprAST{Val: math.Inf(1)}); err == nil {
+		t.Error("EncodeAST of Inf should fail")
+	}
+}
@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// bigMaxBits bounds the size of the results of '<<' and '**' in ParseAndExecBig
+const bigMaxBits = 1 << 24
+
+// bigMaxFactorial is the largest operand of the factorial in ParseAndExecBig
+// and ParseAndExecDecimal, about a million bits
+const bigMaxFactorial = 1 << 16
+
+// ParseAndExecBig is a Top level function
+// Analytical expression and execution in arbitrary-precision integer arithmetic,
+// nothing overflows, e.g. "(2 << 62) * 3" is 27670116110564327424.
+// literals must be integers, / and % truncate toward zero like Go,
+// comparisons and logical operators return 1 or 0, functions and variables are errors.
+// err is not nil if an error occurs (including arithmetic runtime errors)
+func ParseAndExecBig(s string) (*big.Int, error) {
+	toks, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	ast := NewAST(toks, s)
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	ast.exactLiterals = true
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	return bigEval(ar)
+}
+
+// bigEval evaluates an AST on big.Int
+func bigEval(expr ExprAST) (*big.Int, error) {
+	switch expr.(type) {
+	case BinaryExprAST:
+		ast := expr.(BinaryExprAST)
+		l, err := bigEval(ast.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if ast.Op == "?:" {
+			if l.Sign() != 0 {
+				return l, nil
+			}
+			return bigEval(ast.Rhs)
+		}
+		if ast.Op == "&&" && l.Sign() == 0 || ast.Op == "||" && l.Sign() != 0 {
+			return bigBool(l.Sign() != 0), nil
+		}
+		r, err := bigEval(ast.Rhs)
+		if err != nil {
+			return nil, err
+		}
+		switch ast.Op {
+		case "+":
+			return l.Add(l, r), nil
+		case "-":
+			return l.Sub(l, r), nil
+		case "*":
+			return l.Mul(l, r), nil
+		case "/", "%":
+			if r.Sign() == 0 {
+				return nil, evalError(ErrDivisionByZero, ast.Op,
+					fmt.Sprintf("violation of arithmetic specification: a division by zero in ParseAndExecBig: [%s%s%s]",
+						l,
+						ast.Op,
+						r))
+			}
+			if ast.Op == "%" {
+				return l.Rem(l, r), nil
+			}
+			return l.Quo(l, r), nil
+		case "**":
+			if r.Sign() < 0 {
+				return nil, evalError(ErrInvalidOperand, ast.Op,
+					fmt.Sprintf("negative exponent in ParseAndExecBig: [%s**%s]", l, r))
+			}
+			if l.CmpAbs(big.NewInt(1)) > 0 && (!r.IsInt64() || int64(l.BitLen()-1)*r.Int64() > bigMaxBits) {
+				return nil, evalError(ErrOverflow, ast.Op,
+					fmt.Sprintf("the result of [%s**%s] is too large in ParseAndExecBig", l, r))
+			}
+			return l.Exp(l, r, nil), nil
+		case "^":
+			return l.Xor(l, r), nil
+		case "&":
+			return l.And(l, r), nil
+		case "|":
+			return l.Or(l, r), nil
+		case ">>", "<<":
+			if r.Sign() < 0 {
+				return nil, evalError(ErrInvalidOperand, ast.Op,
+					fmt.Sprintf("violation of arithmetic specification: a negative shift count in ParseAndExecBig: [%s%s%s]",
+						l,
+						ast.Op,
+						r))
+			}
+			if ast.Op == ">>" {
+				if !r.IsInt64() || r.Int64() > int64(l.BitLen()) {
+					// every bit is shifted out
+					return big.NewInt(int64(l.Sign()) >> 1), nil
+				}
+				return l.Rsh(l, uint(r.Int64())), nil
+			}
+			if l.Sign() == 0 {
+				return l, nil
+			}
+			if !r.IsInt64() || int64(l.BitLen())+r.Int64() > bigMaxBits {
+				return nil, evalError(ErrOverflow, ast.Op,
+					fmt.Sprintf("the result of [%s<<%s] is too large in ParseAndExecBig", l, r))
+			}
+			return l.Lsh(l, uint(r.Int64())), nil
+		case ">", "<", ">=", "<=", "==", "!=":
+			c := l.Cmp(r)
+			return bigBool(ast.Op == ">" && c > 0 || ast.Op == "<" && c < 0 ||
+				ast.Op == ">=" && c >= 0 || ast.Op == "<=" && c <= 0 ||
+				ast.Op == "==" && c == 0 || ast.Op == "!=" && c != 0), nil
+		case "&&", "||":
+			return bigBool(r.Sign() != 0), nil
+		default:
+			return nil, errors.New(
+				fmt.Sprintf("unknown operator '%s' in ParseAndExecBig", ast.Op))
+		}
+	case UnaryExprAST:
+		u := expr.(UnaryExprAST)
+		v, err := bigEval(u.Operand)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case u.Postfix:
+			return bigFactorial(v, "ParseAndExecBig")
+		case u.Op == "-":
+			return v.Neg(v), nil
+		case u.Op == "+":
+			return v, nil
+		case u.Op == "~":
+			return v.Not(v), nil
+		case u.Op == "!":
+			return bigBool(v.Sign() == 0), nil
+		}
+		return nil, errors.New(
+			fmt.Sprintf("unknown operator '%s' in ParseAndExecBig", u.Op))
+	case ConditionalExprAST:
+		c := expr.(ConditionalExprAST)
+		cond, err := bigEval(c.Cond)
+		if err != nil {
+			return nil, err
+		}
+		if cond.Sign() != 0 {
+			return bigEval(c.Then)
+		}
+		return bigEval(c.Else)
+	case NumberExprAST:
+		n := expr.(NumberExprAST)
+		if n.Str == "" {
+			// built without source text
+			n.Str = Float64ToStr(n.Val)
+		}
+		v, ok := new(big.Rat).SetString(n.Str)
+		if !ok || !v.IsInt() {
+			return nil, errors.New(
+				fmt.Sprintf("literal %s is not an integer in ParseAndExecBig", n.Str))
+		}
+		return new(big.Int).Set(v.Num()), nil
+	case VariableExprAST:
+		return nil, errors.New(
+			fmt.Sprintf("variable '%s' is not supported in ParseAndExecBig", expr.(VariableExprAST).Name))
+	case FunCallerExprAST:
+		return nil, errors.New(
+			fmt.Sprintf("function '%s' is not supported in ParseAndExecBig", expr.(FunCallerExprAST).Name))
+	}
+	return new(big.Int), nil
+}
+
+// bigFactorial is n! of a non-negative integer n, in the evaluation mode mode
+func bigFactorial(n *big.Int, mode string) (*big.Int, error) {
+	if n.Sign() < 0 {
+		return nil, evalError(ErrDomain, "!",
+			fmt.Sprintf("domain error: the factorial of %s is undefined in %s", n, mode))
+	}
+	if !n.IsInt64() || n.Int64() > bigMaxFactorial {
+		return nil, evalError(ErrOverflow, "!",
+			fmt.Sprintf("the result of [%s!] is too large in %s", n, mode))
+	}
+	return new(big.Int).MulRange(1, n.Int64()), nil
+}
+
+func bigBool(b bool) *big.Int {
+	if b {
+		return big.NewInt(1)
+	}
+	return new(big.Int)
+}
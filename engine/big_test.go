@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAndExecBig(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    string
+	}{
+		{"(2 << 62) * 3", "27670116110564327424"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775808 - 1", "-9223372036854775809"},
+		{"2 ** 100", "1267650600228229401496703205376"},
+		{"2 ** 3 ** 2", "512"},
+		{"(2 ** 127 - 1) % 1000000007", "639816141"},
+		{"-7 / 2", "-3"},
+		{"-7 % 2", "-1"},
+		{"1 << 100 >> 98", "4"},
+		{"-8 >> 1", "-4"},
+		{"-1 >> 200", "-1"},
+		{"5 >> 200", "0"},
+		{"0xFF & ~0x0F | 1 ^ 3", "242"},
+		{"2 ** 64 > 2 ** 63 && !0", "1"},
+		{"0 && 1 / 0", "0"},
+		{"1 ? 10 ** 20 : 1 / 0", "100000000000000000000"},
+		{"1e3 + 1", "1001"},
+		{"0 ** 0 + 1 ** 99999999999", "2"},
+		{"1" + strings.Repeat("0", 400) + " % 7", "4"},
+		{"0x" + strings.Repeat("f", 40) + " + 1", "1461501637330902918203684832716283019655932542976"},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecBig(e.Expr)
+		if err != nil || r.String() != e.R {
+			t.Error(err, e, " ParseAndExecBig:", r)
+		}
+	}
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"1 / 0", "a division by zero"},
+		{"1 % (2 - 2)", "a division by zero"},
+		{"1 << -1", "a negative shift count"},
+		{"2 ** -1", "negative exponent"},
+		{"1 << 99999999999", "is too large"},
+		{"3 ** 99999999999", "is too large"},
+		{"1.5 * 2", "literal 1.5 is not an integer"},
+		{"x", "variable 'x' is not supported"},
+		{"abs(1)", "function 'abs' is not supported"},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExecBig(e.Expr); err == nil || !strings.Contains(err.Error(), e.Err) {
+			t.Error(err, e, " ParseAndExecBig")
+		}
+	}
+}
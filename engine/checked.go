@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"fmt"
+)
+
+const (
+	maxInt = int(^uint(0) >> 1)
+	minInt = -maxInt - 1
+)
+
+// ParseAndExecChecked is a Top level function
+// Analytical expression and execution in int arithmetic,
+// where an overflow of + - * / ** is an error instead of wrapping around.
+// it is ParseAndExecInt in Int64Checked with the result as an int,
+// with its literals, operators and errors.
+// err is not nil if an error occurs (including arithmetic runtime errors)
+func ParseAndExecChecked(s string) (int, error) {
+	r, err := ParseAndExecInt(s, Int64Checked)
+	if err != nil {
+		return 0, err
+	}
+	if v := r.Int64(); v < int64(minInt) || v > int64(maxInt) {
+		return 0, evalError(ErrOverflow, "",
+			fmt.Sprintf("integer overflow in ParseAndExecChecked: %d overflows int", v))
+	}
+	return int(r.Int64()), nil
+}
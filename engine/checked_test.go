@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseAndExecChecked(t *testing.T) {
+	max := strconv.Itoa(maxInt)
+	min := "(-" + max + " - 1)"
+	exprs := []struct {
+		Expr string
+		R    int
+	}{
+		{max + " + 0", maxInt},
+		{max + " - 1 + 1", maxInt},
+		{min + " + 1", minInt + 1},
+		{min, minInt},
+		{strconv.Itoa(minInt), minInt},
+		{min + " + " + max, -1},
+		{"-" + max + " * 1", -maxInt},
+		{min + " * 1", minInt},
+		{"7 / 2", 3},
+		{"-7 / 2", -3},
+		{"7 % 3 + (1 << 4) + (2 > 1)", 18},
+		{"0xFF & ~0x0F", 240},
+		{"1e3 + 1", 1001},
+		{"2 ** 3 ** 2", 512},
+		{"(-2) ** 3", -8},
+		{"2 ** 62", 1 << 62},
+		{"(-2) ** 63", minInt},
+		{"7 ** 0 + 0 ** 0", 2},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecChecked(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecChecked:", r)
+		}
+	}
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{max + " + 1", "integer overflow"},
+		{min + " - 1", "integer overflow"},
+		{min + " + -1", "integer overflow"},
+		{"1 - " + min, "integer overflow"},
+		{max + " * 2", "integer overflow"},
+		{min + " * -1", "integer overflow"},
+		{"-1 * " + min, "integer overflow"},
+		{min + " / -1", "integer overflow"},
+		{"2 ** 63", "integer overflow"},
+		{"3 ** 40", "integer overflow"},
+		{"2 ** -1", "negative exponent"},
+		{"1 / 0", "a division by zero"},
+		{"1 % 0", "a division by zero"},
+		{"1 << -1", "a negative shift count"},
+		{"1.5 + 1", "literal 1.5 is not an int"},
+		{"99999999999999999999", "overflows int64"},
+		{"x + 1", "variable 'x' is not supported"},
+		{"abs(1)", "function 'abs' is not supported"},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExecChecked(e.Expr); err == nil || !strings.Contains(err.Error(), e.Err) {
+			t.Error(err, e, " ParseAndExecChecked")
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package engine
+
+// Config holds the optional behaviour of the lexer, parser and evaluator.
+// the zero value is the default behaviour used by Parse and ParseAndExec
+type Config struct {
+	TokenizerConfig
+
+	// SpaceGrouping reads spaces between two digit runs as digit grouping,
+	// e.g. "1 234" is 1234.
+	// it is off by default because "1 234" is otherwise a missing operator error,
+	// and turning it on hides that mistake
+	SpaceGrouping bool
+
+	// CurrencySymbols are prefixes stripped from number literals,
+	// e.g. with "$" configured "$100 + $50" is 150.
+	// an expression may only use one of them, mixing symbols is an error
+	CurrencySymbols []string
+
+	// MiddleDotMultiply reads the middle dot "·" (U+00B7) as "*",
+	// e.g. "3·4" is 12
+	MiddleDotMultiply bool
+
+	// LogicalBitwise lets "&" and "|" short-circuit like logical operators
+	// when the left operand alone decides the bitwise result:
+	// "0 & x" is 0 and "-1 | x" is -1 without evaluating x,
+	// so "0 & (1/0)" is 0 instead of a division by zero error, and with
+	// TrueValue -1 a true left side of "|" short-circuits too.
+	// for any other left operand both sides are evaluated as bitwise operators,
+	// e.g. "1 | 2" is 3, which gives the same result as logical AND/OR when both are 0 or 1
+	LogicalBitwise bool
+
+	// SaturateLiterals clamps a literal out of the float64 range to the largest
+	// float64, or to the smallest when it is negated, instead of a parse error
+	SaturateLiterals bool
+
+	// KeepTrivia records the raw text and surrounding whitespace of each Token,
+	// so the source can be rebuilt with ReconstructExact
+	KeepTrivia bool
+
+	// TrueValue and FalseValue are the results of comparison operators,
+	// default 1 and 0. a TrueValue of 0 means the default,
+	// e.g. TrueValue -1 gives the BASIC convention where "3 > 2" is -1
+	TrueValue, FalseValue float64
+
+	// MaxAbsResult, if positive, is the largest magnitude allowed for any
+	// intermediate or final result, a larger one is an error.
+	// it is a policy limit, not overflow detection
+	MaxAbsResult float64
+
+	// LineComments reads "//" as the start of a comment running to the end of
+	// the input, e.g. "1 + 2 // sum" is 3.
+	// it is off by default, "//" is then two '/' operators and a parse error,
+	// so turning it on rules out giving "//" a meaning as an operator
+	LineComments bool
+
+	// DecimalSeparator and ArgumentSeparator replace '.' in number literals and
+	// ',' between function arguments, e.g. with ',' and ';' as in many European
+	// locales "max(1,5; 2,5)" is 2.5. they must be ASCII and differ,
+	// the zero value keeps the default
+	DecimalSeparator, ArgumentSeparator byte
+
+	// NumberFormat reads the digit grouping of a locale in number literals,
+	// e.g. "1,000,000.5" with CommaGrouping and "1.000.000,5" with DotGrouping.
+	// underscores group digits in every format, "1_000_000" is 1000000
+	NumberFormat NumberFormat
+
+	// ForbidChainedComparisons makes "1 < 2 < 3" a parse error
+	// instead of evaluating it as "(1 < 2) < 3"
+	ForbidChainedComparisons bool
+
+	// ImplicitMultiply inserts the '*' left out between a number or ')' and
+	// a '(' or an identifier, e.g. "2(3+4)", "(1+2)(3+4)", "3pi" and "2x".
+	// a number before an 'e' without exponent digits ends there, "2e" is 2 * e.
+	// it is off by default, a name followed by '(' stays a function call: "x(2)" is not x * 2
+	ImplicitMultiply bool
+
+	// PercentLiterals reads a '%' that no operand follows as a percentage,
+	// with the semantics of a calculator: "200 * 10%" is 20, "200 + 10%" is 220
+	// and "200 - 10%" is 180, the percentage of the left side of '+' and '-'.
+	// a '%' followed by a number, a name, '(' or '~' stays the modulo, "10 % 3" is 1
+	PercentLiterals bool
+
+	// MaxDepth is the deepest nesting of parentheses, unary operators, function
+	// calls and right-associative operators the parser accepts, deeper input is
+	// an ErrLimit ParseError instead of exhausting the stack.
+	// 0 means DefaultMaxDepth
+	MaxDepth int
+
+	// MaxNodes, if positive, is the largest number of AST nodes of an expression,
+	// a larger one is an ErrLimit ParseError
+	MaxNodes int
+
+	// MaxSteps, if positive, is the largest number of nodes an evaluation visits,
+	// e.g. "1 + 2 * 3" takes 5 steps, a longer evaluation is an ErrLimit EvalError.
+	// "?:", a conditional and the short-circuit operators only count the
+	// operands they evaluate
+	MaxSteps int
+}
+
+// NumberFormat is the digit grouping of number literals
+type NumberFormat int
+
+const (
+	// PlainNumbers is the default, digits are only grouped by underscores
+	PlainNumbers NumberFormat = iota
+	// CommaGrouping groups the digits with ',' before the '.', "1,000,000.5".
+	// a ',' followed by three digits and no fourth groups them,
+	// so "max(1,000, 2)" is 1000 and "max(1,2)" is 2
+	CommaGrouping
+	// DotGrouping groups the digits with '.' before the decimal separator ',',
+	// "1.000.000,5", and the function arguments are separated by ';'
+	// unless ArgumentSeparator is set: "max(1.000,5; 2)" is 1000.5
+	DotGrouping
+)
+
+// DefaultMaxDepth is the nesting limit of the parser when Config.MaxDepth is 0
+const DefaultMaxDepth = 1000
+
+func (cfg Config) maxDepth() int {
+	if cfg.MaxDepth <= 0 {
+		return DefaultMaxDepth
+	}
+	return cfg.MaxDepth
+}
+
+func (cfg Config) decimalSeparator() byte {
+	switch {
+	case cfg.DecimalSeparator != 0:
+		return cfg.DecimalSeparator
+	case cfg.NumberFormat == DotGrouping:
+		return ','
+	}
+	return '.'
+}
+
+func (cfg Config) argumentSeparator() byte {
+	if sep := cfg.customArgumentSeparator(); sep != 0 {
+		return sep
+	}
+	return ','
+}
+
+// customArgumentSeparator is the separator replacing ',' between function arguments, 0 for none
+func (cfg Config) customArgumentSeparator() byte {
+	if cfg.ArgumentSeparator == 0 && cfg.NumberFormat == DotGrouping && cfg.DecimalSeparator == 0 {
+		return ';'
+	}
+	return cfg.ArgumentSeparator
+}
+
+// groupSeparator is the digit grouping character of the NumberFormat, 0 for none
+func (cfg Config) groupSeparator() byte {
+	switch cfg.NumberFormat {
+	case CommaGrouping:
+		return ','
+	case DotGrouping:
+		return '.'
+	}
+	return 0
+}
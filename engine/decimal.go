@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ParseAndExecDecimal is a Top level function
+// Analytical expression and execution in fixed-point decimal arithmetic,
+// the result is scaled by 10^scale, e.g. at scale 2 "10.50 + 0.25" is 1075.
+// literals with more than scale fractional digits, and the results of * and /,
+// are rounded half away from zero.
+// comparisons return 1 or 0 at the same scale, bitwise and shift operators are errors.
+// err is not nil if an error occurs or the result doesn't fit in an int
+func ParseAndExecDecimal(s string, scale int) (int, error) {
+	if scale < 0 {
+		return 0, errors.New(fmt.Sprintf("ParseAndExecDecimal: want a scale >= 0 but get %d", scale))
+	}
+	toks, err := Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	ast := NewAST(toks, s)
+	if ast.Err != nil {
+		return 0, ast.Err
+	}
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		return 0, ast.Err
+	}
+	d := &decimalEvaluator{
+		unit: new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil),
+	}
+	v, err := d.eval(ar)
+	if err != nil {
+		return 0, err
+	}
+	if !v.IsInt64() || int64(int(v.Int64())) != v.Int64() {
+		return 0, evalError(ErrOverflow, "", fmt.Sprintf("ParseAndExecDecimal: result %s overflows int", v))
+	}
+	return int(v.Int64()), nil
+}
+
+// decimalEvaluator evaluates an AST on integers scaled by unit
+type decimalEvaluator struct {
+	unit *big.Int
+}
+
+func (d *decimalEvaluator) eval(expr ExprAST) (*big.Int, error) {
+	switch expr.(type) {
+	case BinaryExprAST:
+		ast := expr.(BinaryExprAST)
+		l, err := d.eval(ast.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if ast.Op == "?:" {
+			if l.Sign() != 0 {
+				return l, nil
+			}
+			return d.eval(ast.Rhs)
+		}
+		r, err := d.eval(ast.Rhs)
+		if err != nil {
+			return nil, err
+		}
+		switch ast.Op {
+		case "+":
+			return l.Add(l, r), nil
+		case "-":
+			return l.Sub(l, r), nil
+		case "*":
+			return roundRat(new(big.Rat).SetFrac(l.Mul(l, r), d.unit)), nil
+		case "/":
+			if r.Sign() == 0 {
+				return nil, evalError(ErrDivisionByZero, ast.Op,
+					fmt.Sprintf("violation of arithmetic specification: a division by zero in ParseAndExecDecimal: [%s/%s]",
+						l,
+						r))
+			}
+			return roundRat(new(big.Rat).SetFrac(l.Mul(l, d.unit), r)), nil
+		case "%":
+			if r.Sign() == 0 {
+				return nil, evalError(ErrDivisionByZero, ast.Op,
+					fmt.Sprintf("violation of arithmetic specification: a modulo by zero in ParseAndExecDecimal: [%s%%%s]",
+						l,
+						r))
+			}
+			return l.Rem(l, r), nil
+		case ">", "<", ">=", "<=", "==", "!=":
+			c := l.Cmp(r)
+			if ast.Op == ">" && c > 0 || ast.Op == "<" && c < 0 ||
+				ast.Op == ">=" && c >= 0 || ast.Op == "<=" && c <= 0 ||
+				ast.Op == "==" && c == 0 || ast.Op == "!=" && c != 0 {
+				return new(big.Int).Set(d.unit), nil
+			}
+			return new(big.Int), nil
+		default:
+			return nil, errors.New(
+				fmt.Sprintf("operator '%s' is not supported in decimal mode", ast.Op))
+		}
+	case UnaryExprAST:
+		u := expr.(UnaryExprAST)
+		v, err := d.eval(u.Operand)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case u.Postfix:
+			n, m := new(big.Int).QuoRem(v, d.unit, new(big.Int))
+			if m.Sign() != 0 {
+				return nil, evalError(ErrDomain, u.Op,
+					fmt.Sprintf("domain error: the factorial of a fraction is undefined in decimal mode: [%s!]",
+						new(big.Rat).SetFrac(v, d.unit).FloatString(len(d.unit.String())-1)))
+			}
+			f, err := bigFactorial(n, "ParseAndExecDecimal")
+			if err != nil {
+				return nil, err
+			}
+			return f.Mul(f, d.unit), nil
+		case u.Op == "-":
+			return v.Neg(v), nil
+		case u.Op == "+":
+			return v, nil
+		}
+		return nil, errors.New(
+			fmt.Sprintf("operator '%s' is not supported in decimal mode", u.Op))
+	case ConditionalExprAST:
+		c := expr.(ConditionalExprAST)
+		cond, err := d.eval(c.Cond)
+		if err != nil {
+			return nil, err
+		}
+		if cond.Sign() != 0 {
+			return d.eval(c.Then)
+		}
+		return d.eval(c.Else)
+	case NumberExprAST:
+		n := expr.(NumberExprAST)
+		if n.Str == "" {
+			// built without source text
+			n.Str = Float64ToStr(n.Val)
+		}
+		// exact decimal value of the literal, not its float64 rounding
+		v, _ := new(big.Rat).SetString(n.Str)
+		return roundRat(v.Mul(v, new(big.Rat).SetInt(d.unit))), nil
+	case VariableExprAST:
+		return nil, errors.New(
+			fmt.Sprintf("variable '%s' is not supported in decimal mode", expr.(VariableExprAST).Name))
+	case FunCallerExprAST:
+		return nil, errors.New(
+			fmt.Sprintf("function '%s' is not supported in decimal mode", expr.(FunCallerExprAST).Name))
+	}
+	return new(big.Int), nil
+}
+
+// roundRat rounds v to an integer, half away from zero
+func roundRat(v *big.Rat) *big.Int {
+	q, m := new(big.Int).QuoRem(v.Num(), v.Denom(), new(big.Int))
+	if m.Abs(m).Lsh(m, 1).Cmp(v.Denom()) >= 0 {
+		if v.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
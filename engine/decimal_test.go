@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestParseAndExecDecimal(t *testing.T) {
+	exprs := []struct {
+		Expr  string
+		Scale int
+		R     int
+	}{
+		{"10.50 + 0.25", 2, 1075},
+		{"10.50 - 0.25", 2, 1025},
+		{"-(10.50 - 0.25)", 2, -1025},
+		{"1.5 * 2.25", 2, 338},
+		{"-1.5 * 2.25", 2, -338},
+		{"19.99 * 3", 2, 5997},
+		{"10 / 3", 2, 333},
+		{"20 / 3", 2, 667},
+		{"0.125 + 0", 2, 13},
+		{"1e2 + 0.5", 1, 1005},
+		{"7 % 2.5", 2, 200},
+		{"2.5 > 2.49", 2, 100},
+		{"7 / 2", 0, 4},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecDecimal(e.Expr, e.Scale)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecDecimal:", r)
+		}
+	}
+	errs := []string{
+		"1 / 0",
+		"1 % 0",
+		"1 << 2",
+		"6 & 3",
+		"1.2.3 + 1",
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExecDecimal(e, 2); err == nil {
+			t.Error(e, " this is error expr in decimal mode!")
+		}
+	}
+}
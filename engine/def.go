@@ -0,0 +1,330 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+const (
+	RadianMode = iota
+	AngleMode
+)
+
+// TrigonometricMode is the unit of the arguments of the trigonometric functions,
+// RadianMode or AngleMode
+var TrigonometricMode = RadianMode
+
+// anyArgc is the argc of a function taking any number of arguments
+const anyArgc = -2
+
+type defS struct {
+	// number of arguments, -1 for one or more or anyArgc
+	argc int
+	fun  func(args ...float64) float64
+}
+
+// defConst are the built-in constants, a variable of the same name takes precedence
+var defConst = map[string]float64{
+	"pi":  math.Pi,
+	"e":   math.E,
+	"tau": 2 * math.Pi,
+	"phi": math.Phi,
+}
+
+// userConst are the constants registered with RegisterConst
+var (
+	userConstMu sync.RWMutex
+	userConst   = map[string]float64{}
+)
+
+// RegisterConst is a Top level function
+// registers value as the constant name, usable anywhere a number is.
+// a variable of the same name takes precedence, a built-in constant can't be replaced.
+// the registry is global and safe for concurrent use
+func RegisterConst(name string, value float64) error {
+	if !validName(name) {
+		return errors.New(fmt.Sprintf("RegisterConst: invalid constant name '%s'", name))
+	}
+	if _, ok := defConst[name]; ok {
+		return errors.New(fmt.Sprintf("RegisterConst: constant '%s' is built in", name))
+	}
+	userConstMu.Lock()
+	defer userConstMu.Unlock()
+	userConst[name] = value
+	return nil
+}
+
+// UnregisterConst is a Top level function
+// removes the registered constant name
+func UnregisterConst(name string) {
+	userConstMu.Lock()
+	defer userConstMu.Unlock()
+	delete(userConst, name)
+}
+
+// lookupConst finds the registered or built-in constant name
+func lookupConst(name string) (float64, bool) {
+	userConstMu.RLock()
+	v, ok := userConst[name]
+	userConstMu.RUnlock()
+	if ok {
+		return v, true
+	}
+	v, ok = defConst[name]
+	return v, ok
+}
+
+// constNames are the names of the registered and built-in constants
+func constNames() []string {
+	userConstMu.RLock()
+	defer userConstMu.RUnlock()
+	names := make([]string, 0, len(defConst)+len(userConst))
+	for name := range defConst {
+		names = append(names, name)
+	}
+	for name := range userConst {
+		names = append(names, name)
+	}
+	return names
+}
+
+// defFunc are the built-in functions,
+// with finite arguments a result that is NaN or infinite is a domain error,
+// or an overflow, see checkDomain
+var defFunc = map[string]defS{
+	"sin": {1, func(args ...float64) float64 {
+		return math.Sin(expr2Radian(args[0]))
+	}},
+	"cos": {1, func(args ...float64) float64 {
+		return math.Cos(expr2Radian(args[0]))
+	}},
+	"tan": {1, func(args ...float64) float64 {
+		return math.Tan(expr2Radian(args[0]))
+	}},
+	"cot": {1, func(args ...float64) float64 {
+		return 1 / math.Tan(expr2Radian(args[0]))
+	}},
+	"sec": {1, func(args ...float64) float64 {
+		return 1 / math.Cos(expr2Radian(args[0]))
+	}},
+	"csc": {1, func(args ...float64) float64 {
+		return 1 / math.Sin(expr2Radian(args[0]))
+	}},
+	"abs": {1, func(args ...float64) float64 {
+		return math.Abs(args[0])
+	}},
+	"ceil": {1, func(args ...float64) float64 {
+		return math.Ceil(args[0])
+	}},
+	"floor": {1, func(args ...float64) float64 {
+		return math.Floor(args[0])
+	}},
+	"round": {1, func(args ...float64) float64 {
+		return math.Round(args[0])
+	}},
+	"sqrt": {1, func(args ...float64) float64 {
+		return math.Sqrt(args[0])
+	}},
+	"cbrt": {1, func(args ...float64) float64 {
+		return math.Cbrt(args[0])
+	}},
+	"log": {1, func(args ...float64) float64 {
+		return math.Log10(args[0])
+	}},
+	"ln": {1, func(args ...float64) float64 {
+		return math.Log(args[0])
+	}},
+	"pow": {2, func(args ...float64) float64 {
+		return math.Pow(args[0], args[1])
+	}},
+	"max": {-1, func(args ...float64) float64 {
+		r := args[0]
+		for _, v := range args[1:] {
+			r = math.Max(r, v)
+		}
+		return r
+	}},
+	"min": {-1, func(args ...float64) float64 {
+		r := args[0]
+		for _, v := range args[1:] {
+			r = math.Min(r, v)
+		}
+		return r
+	}},
+	// evaluated by the evaluator, which returns 0 if its argument is an error
+	"noerr": {1, nil},
+}
+
+// userDef is a function registered with RegisterFunc, RegisterFunction or RegFunction
+type userDef struct {
+	argc int
+	fn   func(args []float64) (float64, error)
+	// exprFn is called with the evaluated arguments as NumberExprAST, instead of fn
+	exprFn func(expr ...ExprAST) float64
+}
+
+// userFunc are the registered functions
+var (
+	userFuncMu sync.RWMutex
+	userFunc   = map[string]userDef{}
+)
+
+// RegisterFunc is a Top level function
+// registers fn as the function name, it takes precedence over a built-in of the same name.
+// fn is called with the evaluated arguments, of any number,
+// and an error it returns is the error of the evaluation.
+// the name must start with a letter and only contain letters and digits, else err is not nil.
+// the registry is global, shared by all expressions including compiled ones,
+// and safe for concurrent use
+func RegisterFunc(name string, fn func(args []float64) (float64, error)) error {
+	if !validName(name) {
+		return errors.New(fmt.Sprintf("RegisterFunc: invalid function name '%s'", name))
+	}
+	userFuncMu.Lock()
+	defer userFuncMu.Unlock()
+	userFunc[name] = userDef{argc: anyArgc, fn: fn}
+	return nil
+}
+
+// RegisterFunction is a Top level function
+// like RegisterFunc, for a function taking argc arguments, -1 for one or more.
+// a call with another number of arguments is a parse error
+func RegisterFunction(name string, argc int, fn func(args ...float64) (float64, error)) error {
+	if err := checkRegister("RegisterFunction", name, argc); err != nil {
+		return err
+	}
+	userFuncMu.Lock()
+	defer userFuncMu.Unlock()
+	userFunc[name] = userDef{argc: argc, fn: func(args []float64) (float64, error) {
+		return fn(args...)
+	}}
+	return nil
+}
+
+// RegFunction is a Top level function
+// the same function name only needs to be registered once.
+// argc is the number of parameters, -1 for one or more.
+// fun gets the arguments evaluated by the running evaluation, as NumberExprAST,
+// use ExprASTResult to get their values, a panic in fun is the error of the evaluation
+func RegFunction(name string, argc int, fun func(expr ...ExprAST) float64) error {
+	if err := checkRegister("RegFunction", name, argc); err != nil {
+		return err
+	}
+	userFuncMu.Lock()
+	defer userFuncMu.Unlock()
+	if _, ok := userFunc[name]; ok {
+		return errors.New(fmt.Sprintf("RegFunction: function '%s' already exists", name))
+	}
+	if _, ok := defFunc[name]; ok {
+		return errors.New(fmt.Sprintf("RegFunction: function '%s' already exists", name))
+	}
+	userFunc[name] = userDef{argc: argc, exprFn: fun}
+	return nil
+}
+
+// validName reports whether name starts with a letter and only contains letters and digits
+func validName(name string) bool {
+	valid := name != "" && DefaultRuneClass(rune(name[0])) == RuneIdentifier
+	for i := 0; i < len(name); i++ {
+		cls := DefaultRuneClass(rune(name[i]))
+		valid = valid && (cls == RuneIdentifier || cls == RuneDigit)
+	}
+	return valid
+}
+
+// checkRegister validates the name and argc of a function to register
+func checkRegister(caller, name string, argc int) error {
+	if !validName(name) {
+		return errors.New(fmt.Sprintf("%s: invalid function name '%s'", caller, name))
+	}
+	if argc < -1 {
+		return errors.New(fmt.Sprintf("%s: argc should be -1, 0, or a positive integer but get %d", caller, argc))
+	}
+	return nil
+}
+
+// Unregister is a Top level function
+// removes the registered function name,
+// a built-in of the same name is used again
+func Unregister(name string) {
+	userFuncMu.Lock()
+	defer userFuncMu.Unlock()
+	delete(userFunc, name)
+}
+
+// lookupFunc finds the registered or built-in function name,
+// user is the registered function, nil for a built-in
+func lookupFunc(name string) (def defS, user *userDef, ok bool) {
+	userFuncMu.RLock()
+	u, ok := userFunc[name]
+	userFuncMu.RUnlock()
+	if ok {
+		return defS{argc: u.argc}, &u, true
+	}
+	def, ok = defFunc[name]
+	return def, nil, ok
+}
+
+// funcNames are the names of the registered and built-in functions
+func funcNames() []string {
+	userFuncMu.RLock()
+	defer userFuncMu.RUnlock()
+	names := make([]string, 0, len(defFunc)+len(userFunc))
+	for name := range defFunc {
+		names = append(names, name)
+	}
+	for name := range userFunc {
+		names = append(names, name)
+	}
+	return names
+}
+
+// expr2Radian converts the argument of a trigonometric function to radians
+func expr2Radian(r float64) float64 {
+	if TrigonometricMode == AngleMode {
+		r = r / 180 * math.Pi
+	}
+	return r
+}
+
+// checkDomain returns an error if the built-in function name gave a NaN
+// or infinite result r for finite args, e.g. sqrt(-1) or ln(0).
+// pow of a base other than 0 is only infinite when it overflows float64,
+// e.g. pow(10, 400), that is an overflow instead of a domain error
+func checkDomain(name string, args []float64, r float64) error {
+	if !math.IsNaN(r) && !math.IsInf(r, 0) {
+		return nil
+	}
+	s := make([]string, len(args))
+	for i, a := range args {
+		if math.IsNaN(a) || math.IsInf(a, 0) {
+			return nil
+		}
+		s[i] = Float64ToStr(a)
+	}
+	if name == "pow" && math.IsInf(r, 0) && args[0] != 0 {
+		return evalError(ErrOverflow, name,
+			fmt.Sprintf("the result of %s(%s) overflows float64", name, strings.Join(s, ", ")))
+	}
+	return evalError(ErrDomain, name,
+		fmt.Sprintf("domain error: %s(%s) has no finite real result", name, strings.Join(s, ", ")))
+}
+
+// checkArgc returns an error if the function def can't be called with n arguments
+func checkArgc(name string, def defS, n int) error {
+	if def.argc == anyArgc {
+		return nil
+	}
+	if def.argc < 0 && n == 0 {
+		return evalError(ErrSyntax, name,
+			fmt.Sprintf("wrong way calling function '%s', parameters want at least 1 but get 0", name))
+	}
+	if def.argc >= 0 && n != def.argc {
+		return evalError(ErrSyntax, name,
+			fmt.Sprintf("wrong way calling function '%s', parameters want %d but get %d", name, def.argc, n))
+	}
+	return nil
+}
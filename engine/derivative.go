@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Derivative is a Top level function
+// returns the derivative of expr with respect to the variable wrt,
+// e.g. that of "x ** 2 * sin(x)" is "2 * x ** 1 * sin(x) + x ** 2 * cos(x)"
+// after Simplify. the other variables and the constants are constant,
+// a subtree that doesn't use wrt has the derivative 0.
+// the sum, product, quotient, power and chain rules cover the arithmetic operators,
+// the unary minus and plus, the percentage and the functions sin cos tan cot sec csc,
+// abs sqrt cbrt log ln and pow. a conditional, "?:" and abs are differentiated piece by piece.
+// the trigonometric derivatives are in the current TrigonometricMode.
+// err is not nil if wrt reaches an operator or a function without a derivative,
+// e.g. a comparison, floor or a registered function
+func Derivative(expr ExprAST, wrt string) (ExprAST, error) {
+	if !validName(wrt) {
+		return nil, errors.New(fmt.Sprintf("Derivative: invalid variable name '%s'", wrt))
+	}
+	return derive(expr, wrt)
+}
+
+func derive(expr ExprAST, wrt string) (ExprAST, error) {
+	if !dependsOn(expr, wrt) {
+		return number(0), nil
+	}
+	switch e := expr.(type) {
+	case VariableExprAST:
+		// the variable wrt itself
+		return number(1), nil
+	case BinaryExprAST:
+		if percentOf(e) {
+			// "x + p%" is x + x * p%
+			e = BinaryExprAST{Op: e.Op, Lhs: e.Lhs, Rhs: binOp("*", e.Lhs, e.Rhs)}
+		}
+		return deriveBinary(e, wrt)
+	case UnaryExprAST:
+		d, err := derive(e.Operand, wrt)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case e.Postfix && e.Op == "%":
+			return binOp("/", d, number(100)), nil
+		case !e.Postfix && (e.Op == "-" || e.Op == "+"):
+			return UnaryExprAST{Op: e.Op, Operand: d}, nil
+		}
+		return nil, errors.New(
+			fmt.Sprintf("Derivative: operator '%s' has no derivative", unaryName(e)))
+	case ConditionalExprAST:
+		then, err := derive(e.Then, wrt)
+		if err != nil {
+			return nil, err
+		}
+		els, err := derive(e.Else, wrt)
+		if err != nil {
+			return nil, err
+		}
+		return ConditionalExprAST{Cond: e.Cond, Then: then, Else: els}, nil
+	case FunCallerExprAST:
+		return deriveCall(e, wrt)
+	}
+	return nil, errors.New(fmt.Sprintf("Derivative: unsupported node %T", expr))
+}
+
+// deriveBinary applies the rule of the operator of b
+func deriveBinary(b BinaryExprAST, wrt string) (ExprAST, error) {
+	l, r := b.Lhs, b.Rhs
+	dl, err := derive(l, wrt)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := derive(r, wrt)
+	if err != nil {
+		return nil, err
+	}
+	switch b.Op {
+	case "+", "-":
+		return binOp(b.Op, dl, dr), nil
+	case "*":
+		return binOp("+", binOp("*", dl, r), binOp("*", l, dr)), nil
+	case "/":
+		return binOp("/",
+			binOp("-", binOp("*", dl, r), binOp("*", l, dr)),
+			binOp("**", r, number(2))), nil
+	case "**":
+		return derivePow(l, r, dl, dr, wrt), nil
+	case "?:":
+		// l when it is not 0, else r
+		return ConditionalExprAST{Cond: l, Then: dl, Else: dr}, nil
+	}
+	return nil, errors.New(
+		fmt.Sprintf("Derivative: operator '%s' has no derivative", b.Op))
+}
+
+// derivePow is the derivative of l ** r, dl and dr are those of l and r
+func derivePow(l, r, dl, dr ExprAST, wrt string) ExprAST {
+	if !dependsOn(r, wrt) {
+		// power rule, r * l ** (r - 1) * l'
+		return binOp("*", binOp("*", r, binOp("**", l, binOp("-", r, number(1)))), dl)
+	}
+	ln := FunCallerExprAST{Name: "ln", Arg: []ExprAST{l}}
+	if !dependsOn(l, wrt) {
+		// l ** r * ln(l) * r'
+		return binOp("*", binOp("*", binOp("**", l, r), ln), dr)
+	}
+	// l ** r * (r' * ln(l) + r * l' / l)
+	return binOp("*", binOp("**", l, r),
+		binOp("+", binOp("*", dr, ln), binOp("/", binOp("*", r, dl), l)))
+}
+
+// deriveCall applies the chain rule to a built-in function
+func deriveCall(f FunCallerExprAST, wrt string) (ExprAST, error) {
+	call := func(name string, arg ExprAST) ExprAST {
+		return FunCallerExprAST{Name: name, Arg: []ExprAST{arg}}
+	}
+	if f.Name == "pow" && len(f.Arg) == 2 {
+		return deriveBinary(binOp("**", f.Arg[0], f.Arg[1]), wrt)
+	}
+	if len(f.Arg) != 1 {
+		return nil, errors.New(
+			fmt.Sprintf("Derivative: function '%s' has no derivative", f.Name))
+	}
+	u := f.Arg[0]
+	du, err := derive(u, wrt)
+	if err != nil {
+		return nil, err
+	}
+	var outer ExprAST
+	switch f.Name {
+	case "sin":
+		outer = call("cos", u)
+	case "cos":
+		outer = UnaryExprAST{Op: "-", Operand: call("sin", u)}
+	case "tan":
+		outer = binOp("**", call("sec", u), number(2))
+	case "cot":
+		outer = UnaryExprAST{Op: "-", Operand: binOp("**", call("csc", u), number(2))}
+	case "sec":
+		outer = binOp("*", call("sec", u), call("tan", u))
+	case "csc":
+		outer = UnaryExprAST{Op: "-", Operand: binOp("*", call("csc", u), call("cot", u))}
+	case "abs":
+		outer = ConditionalExprAST{Cond: binOp("<", u, number(0)), Then: number(-1), Else: number(1)}
+	case "sqrt":
+		outer = binOp("/", number(1), binOp("*", number(2), call("sqrt", u)))
+	case "cbrt":
+		outer = binOp("/", number(1), binOp("*", number(3), binOp("**", call("cbrt", u), number(2))))
+	case "ln":
+		outer = binOp("/", number(1), u)
+	case "log":
+		outer = binOp("/", number(1), binOp("*", u, number(math.Ln10)))
+	default:
+		return nil, errors.New(
+			fmt.Sprintf("Derivative: function '%s' has no derivative", f.Name))
+	}
+	switch f.Name {
+	case "sin", "cos", "tan", "cot", "sec", "csc":
+		if TrigonometricMode == AngleMode {
+			// the argument is in degrees
+			outer = binOp("*", outer, number(math.Pi/180))
+		}
+	}
+	return binOp("*", outer, du), nil
+}
+
+// dependsOn reports whether the variable wrt occurs in expr
+func dependsOn(expr ExprAST, wrt string) bool {
+	if v, ok := expr.(VariableExprAST); ok {
+		return v.Name == wrt
+	}
+	for _, c := range Children(expr) {
+		if dependsOn(c, wrt) {
+			return true
+		}
+	}
+	return false
+}
+
+func binOp(op string, l, r ExprAST) BinaryExprAST {
+	return BinaryExprAST{Op: op, Lhs: l, Rhs: r}
+}
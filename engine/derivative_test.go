@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDerivative(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		Want string
+	}{
+		{"3", "0"},
+		{"y * 2", "0"},
+		{"x", "1"},
+		{"x ** 3", "(3 * (x ** 2))"},
+		{"2 * x + y", "2"},
+		{"x * y", "y"},
+		{"sin(x)", "cos(x)"},
+		{"-x", "-1"},
+		{"1 / x", "(-1 / (x ** 2))"},
+		{"ln(x)", "(1 / x)"},
+	}
+	for _, e := range exprs {
+		d, err := Derivative(parseExpr(t, e.Expr), "x")
+		if err != nil {
+			t.Error(err, e)
+			continue
+		}
+		if s := ExprASTToString(Simplify(d)); s != e.Want {
+			t.Error(e, " Derivative:", s)
+		}
+	}
+
+	// against a central difference
+	funcs := []string{
+		"x ** 2 * sin(x)",
+		"(x + 1) / (x - 3)",
+		"2 ** x",
+		"x ** x",
+		"pow(x, 3) - 4 * x",
+		"cos(x ** 2) + tan(x)",
+		"cot(x) + sec(x) - csc(x)",
+		"sqrt(x) * cbrt(x)",
+		"ln(x) + log(x)",
+		"abs(x - 2)",
+		"x > 1 ? x ** 2 : -x",
+		"-(x * y) + +x",
+		"exp2 ?: x * 3",
+	}
+	vars := map[string]float64{"x": 1.3, "y": 2, "exp2": 0}
+	for _, f := range funcs {
+		ar := parseExpr(t, f)
+		d, err := Derivative(ar, "x")
+		if err != nil {
+			t.Error(err, f)
+			continue
+		}
+		r, err := (&Expression{Root: d}).Eval(vars)
+		if err != nil {
+			t.Error(err, f)
+			continue
+		}
+		if want := centralDifference(ar, vars); math.Abs(r-want) > 1e-5 {
+			t.Error(f, " Derivative:", r, " want:", want)
+		}
+	}
+
+	TrigonometricMode = AngleMode
+	d, err := Derivative(parseExpr(t, "sin(x)"), "x")
+	TrigonometricMode = RadianMode
+	if err != nil {
+		t.Fatal(err)
+	}
+	TrigonometricMode = AngleMode
+	r, err := (&Expression{Root: d}).Eval(map[string]float64{"x": 60})
+	TrigonometricMode = RadianMode
+	if err != nil || math.Abs(r-math.Pi/360) > 1e-12 {
+		t.Error(err, " Derivative AngleMode:", r)
+	}
+
+	cfg := Config{PercentLiterals: true}
+	ex, err := CompileWithConfig("x + 10%", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, err := Derivative(ex.Root, "x"); err != nil || ExprASTResultWith(d, vars) != 1.1 {
+		t.Error(err, " Derivative percentage")
+	}
+
+	for _, e := range []string{"x > 1", "floor(x)", "max(x, 1)", "x!", "x % 2", "x & 1"} {
+		if _, err := Derivative(parseExpr(t, e), "x"); err == nil {
+			t.Error(e, " Derivative: want an error")
+		}
+	}
+	if d, err := Derivative(parseExpr(t, "floor(y)"), "x"); err != nil || ExprASTToString(d) != "0" {
+		t.Error(err, " Derivative of a constant function")
+	}
+	if _, err := Derivative(parseExpr(t, "x"), "1x"); err == nil {
+		t.Error("Derivative: want an invalid name error")
+	}
+}
+
+func centralDifference(expr ExprAST, vars map[string]float64) float64 {
+	const h = 1e-6
+	at := func(x float64) float64 {
+		v := map[string]float64{}
+		for k, val := range vars {
+			v[k] = val
+		}
+		v["x"] = x
+		return ExprASTResultWith(expr, v)
+	}
+	return (at(vars["x"]+h) - at(vars["x"]-h)) / (2 * h)
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// jsonNode is the JSON form of an ExprAST node, Type selects the node type
+type jsonNode struct {
+	Type    string      `json:"type"`
+	Op      string      `json:"op,omitempty"`
+	Value   float64     `json:"value,omitempty"`
+	Str     string      `json:"str,omitempty"`
+	Name    string      `json:"name,omitempty"`
+	Offset  int         `json:"offset,omitempty"`
+	Lhs     *jsonNode   `json:"lhs,omitempty"`
+	Rhs     *jsonNode   `json:"rhs,omitempty"`
+	Operand *jsonNode   `json:"operand,omitempty"`
+	Postfix bool        `json:"postfix,omitempty"`
+	Cond    *jsonNode   `json:"cond,omitempty"`
+	Then    *jsonNode   `json:"then,omitempty"`
+	Else    *jsonNode   `json:"else,omitempty"`
+	Args    []*jsonNode `json:"args,omitempty"`
+}
+
+// EncodeAST is a Top level function
+// serializes expr as JSON, DecodeAST restores the identical AST,
+// including the literal text of numbers and the source offsets of variables.
+// e.g. "x + 1" is {"type":"binary","op":"+","lhs":{"type":"variable","name":"x"},
+// "rhs":{"type":"number","value":1,"str":"1"}}
+func EncodeAST(expr ExprAST) ([]byte, error) {
+	n, err := encodeNode(expr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(n)
+}
+
+func encodeNode(expr ExprAST) (*jsonNode, error) {
+	var err error
+	enc := func(expr ExprAST) *jsonNode {
+		if err != nil {
+			return nil
+		}
+		var n *jsonNode
+		n, err = encodeNode(expr)
+		return n
+	}
+	var n *jsonNode
+	switch e := expr.(type) {
+	case nil:
+		return nil, nil
+	case NumberExprAST:
+		if math.IsNaN(e.Val) || math.IsInf(e.Val, 0) {
+			return nil, errors.New(fmt.Sprintf("EncodeAST: number %v can't be encoded in JSON", e.Val))
+		}
+		n = &jsonNode{Type: "number", Value: e.Val, Str: e.Str}
+	case BinaryExprAST:
+		n = &jsonNode{Type: "binary", Op: e.Op, Lhs: enc(e.Lhs), Rhs: enc(e.Rhs)}
+	case UnaryExprAST:
+		n = &jsonNode{Type: "unary", Op: e.Op, Operand: enc(e.Operand), Postfix: e.Postfix}
+	case VariableExprAST:
+		n = &jsonNode{Type: "variable", Name: e.Name, Offset: e.Offset}
+	case ConditionalExprAST:
+		n = &jsonNode{Type: "conditional", Cond: enc(e.Cond), Then: enc(e.Then), Else: enc(e.Else)}
+	case FunCallerExprAST:
+		n = &jsonNode{Type: "call", Name: e.Name}
+		for _, arg := range e.Arg {
+			n.Args = append(n.Args, enc(arg))
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("EncodeAST: unknown node type %T", expr))
+	}
+	return n, err
+}
+
+// DecodeAST is a Top level function
+// restores an AST serialized by EncodeAST, it can be evaluated without parsing
+func DecodeAST(data []byte) (ExprAST, error) {
+	var n *jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	if n == nil {
+		return nil, nil
+	}
+	return decodeNode(n)
+}
+
+func decodeNode(n *jsonNode) (ExprAST, error) {
+	var err error
+	dec := func(field string, c *jsonNode) ExprAST {
+		if err != nil {
+			return nil
+		}
+		if c == nil {
+			err = errors.New(fmt.Sprintf("DecodeAST: %s node without %s", n.Type, field))
+			return nil
+		}
+		var expr ExprAST
+		expr, err = decodeNode(c)
+		return expr
+	}
+	var expr ExprAST
+	switch n.Type {
+	case "number":
+		expr = NumberExprAST{Val: n.Value, Str: n.Str}
+	case "binary":
+		expr = BinaryExprAST{Op: n.Op, Lhs: dec("lhs", n.Lhs), Rhs: dec("rhs", n.Rhs)}
+	case "unary":
+		expr = UnaryExprAST{Op: n.Op, Operand: dec("operand", n.Operand), Postfix: n.Postfix}
+	case "variable":
+		expr = VariableExprAST{Name: n.Name, Offset: n.Offset}
+	case "conditional":
+		expr = ConditionalExprAST{Cond: dec("cond", n.Cond), Then: dec("then", n.Then), Else: dec("else", n.Else)}
+	case "call":
+		f := FunCallerExprAST{Name: n.Name}
+		for _, arg := range n.Args {
+			f.Arg = append(f.Arg, dec("argument", arg))
+		}
+		expr = f
+	default:
+		return nil, errors.New(fmt.Sprintf("DecodeAST: unknown node type '%s'", n.Type))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
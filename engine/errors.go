@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrorKind classifies a ParseError or an EvalError.
+// it is an error itself, errors.Is(err, ErrDivisionByZero) reports
+// whether err is a ParseError or an EvalError of that kind
+type ErrorKind int
+
+const (
+	// ErrSyntax is a malformed expression: an unknown symbol,
+	// a missing operand or parenthesis, a wrong number of arguments
+	ErrSyntax ErrorKind = iota + 1
+	// ErrUnknownIdentifier is an undefined function or variable
+	ErrUnknownIdentifier
+	// ErrDivisionByZero is a division or a modulo by zero
+	ErrDivisionByZero
+	// ErrOverflow is a result out of the range of the evaluation mode
+	// or of Config.MaxAbsResult
+	ErrOverflow
+	// ErrDomain is a function without a finite real result for its arguments
+	ErrDomain
+	// ErrInvalidOperand is an operand the operator can't take,
+	// such as a negative shift count or a fractional operand of '~'
+	ErrInvalidOperand
+	// ErrLimit is an expression beyond Config.MaxDepth, MaxNodes or MaxSteps
+	ErrLimit
+	// ErrCanceled is an evaluation stopped because its context is done,
+	// errors.Is also matches the error of the context
+	ErrCanceled
+)
+
+func (k ErrorKind) Error() string {
+	switch k {
+	case ErrSyntax:
+		return "syntax error"
+	case ErrUnknownIdentifier:
+		return "unknown identifier"
+	case ErrDivisionByZero:
+		return "division by zero"
+	case ErrOverflow:
+		return "overflow"
+	case ErrDomain:
+		return "domain error"
+	case ErrInvalidOperand:
+		return "invalid operand"
+	case ErrLimit:
+		return "limit exceeded"
+	case ErrCanceled:
+		return "evaluation canceled"
+	}
+	return fmt.Sprintf("error kind %d", int(k))
+}
+
+// ParseError is an error of the tokenizer or of the parser
+type ParseError struct {
+	Kind ErrorKind
+	// Msg describes the error, without the position
+	Msg string
+	// Token is the offending token
+	Token string
+	// Offset is the byte offset of Token in the source,
+	// Line and Column its 1-based position, Column counts runes
+	Offset,
+	Line,
+	Column int
+
+	source string
+}
+
+// newParseError is a ParseError at offset of source
+func newParseError(kind ErrorKind, source string, offset int, token, msg string) *ParseError {
+	line, col := position(source, offset)
+	return &ParseError{
+		Kind:   kind,
+		Msg:    msg,
+		Token:  token,
+		Offset: offset,
+		Line:   line,
+		Column: col,
+		source: source,
+	}
+}
+
+// Error returns Msg followed by the source marked with ErrPos
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s\n%s", e.Msg, ErrPos(e.source, e.Offset))
+}
+
+func (e *ParseError) Is(target error) bool {
+	k, ok := target.(ErrorKind)
+	return ok && k == e.Kind
+}
+
+// EvalError is an error of the evaluation of a parsed expression
+type EvalError struct {
+	Kind ErrorKind
+	Msg  string
+	// Token is the operator, function or variable in error
+	Token string
+	// Offset is the byte offset of Token in the source, or -1 if unknown,
+	// Line and Column are only set by the evaluation of an Expression
+	Offset,
+	Line,
+	Column int
+
+	// undefinedVar is set for a variable missing from vars
+	undefinedVar bool
+	// cause is the error of the context of an ErrCanceled
+	cause error
+}
+
+func (e *EvalError) Error() string {
+	return e.Msg
+}
+
+func (e *EvalError) Is(target error) bool {
+	k, ok := target.(ErrorKind)
+	return ok && k == e.Kind
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.cause
+}
+
+// evalError is an EvalError of token without a known position
+func evalError(kind ErrorKind, token, msg string) *EvalError {
+	return &EvalError{Kind: kind, Msg: msg, Token: token, Offset: -1}
+}
+
+// position returns the 1-based line and rune column of offset in source
+func position(source string, offset int) (line, col int) {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	before := source[:offset]
+	line = strings.Count(before, "\n") + 1
+	if i := strings.LastIndexByte(before, '\n'); i >= 0 {
+		before = before[i+1:]
+	}
+	return line, utf8.RuneCountInString(before) + 1
+}
+
+// Validate is a Top level function
+// returns all the problems of s, nil if it is a valid expression.
+// unknown symbols, undefined functions and wrong numbers of arguments are
+// collected, the first other syntax error ends the validation
+func Validate(s string) []error {
+	if s == "" {
+		return []error{errors.New("empty token")}
+	}
+	p := &Parser{
+		Source:  s,
+		ch:      s[0],
+		collect: true,
+	}
+	toks := p.parse()
+	errs := p.errs
+	if p.err != nil {
+		return append(errs, p.err)
+	}
+	a := NewAST(toks, s)
+	if a.Err != nil {
+		return append(errs, a.Err)
+	}
+	a.collect = true
+	a.ParseExpression()
+	errs = append(errs, a.errs...)
+	if a.Err != nil {
+		errs = append(errs, a.Err)
+	}
+	return errs
+}
+
+// closeMatch returns the candidate closest to the unknown identifier name,
+// "" if none is within a third of its length in edits
+func closeMatch(name string, candidates []string) string {
+	max := len(name) / 3
+	if max < 1 {
+		max = 1
+	}
+	best, bestDist := "", max+1
+	for _, c := range candidates {
+		d := editDistance(name, c)
+		if d < len(name) && (d < bestDist || d == bestDist && c < best) {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// didYouMean is the suggestion appended to an error about name, "" without a close match
+func didYouMean(name string, candidates []string) string {
+	if m := closeMatch(name, candidates); m != "" && m != name {
+		return fmt.Sprintf(", did you mean '%s'?", m)
+	}
+	return ""
+}
+
+// editDistance is the optimal string alignment distance between a and b,
+// the Levenshtein distance where swapping two adjacent letters is one edit
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = d[i-1][j-1] + cost
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	type U struct {
+		Expr   string
+		Kind   ErrorKind
+		Token  string
+		Offset int
+		Line   int
+		Column int
+	}
+	exprs := []U{
+		{"1 + $", ErrSyntax, "$", 4, 1, 5},
+		{"1 +", ErrSyntax, "+", 2, 1, 3},
+		{"nofunc(1)", ErrUnknownIdentifier, "nofunc", 0, 1, 1},
+		{"1 +\n sqrt(1, 2)", ErrSyntax, "sqrt", 5, 2, 2},
+		{"0xFFFFFFFFFFFFFFFFFF", ErrOverflow, "0xFFFFFFFFFFFFFFFFFF", 0, 1, 1},
+	}
+	for _, e := range exprs {
+		_, err := ParseAndExec(e.Expr)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Error(err, e, " want a ParseError")
+			continue
+		}
+		if pe.Kind != e.Kind || pe.Token != e.Token || pe.Offset != e.Offset ||
+			pe.Line != e.Line || pe.Column != e.Column {
+			t.Error(e, " ParseError:", pe.Kind, pe.Token, pe.Offset, pe.Line, pe.Column)
+		}
+		if !errors.Is(err, e.Kind) {
+			t.Error(e, " errors.Is:", err)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	type U struct {
+		Expr string
+		Kind ErrorKind
+	}
+	exprs := []U{
+		{"1/0", ErrDivisionByZero},
+		{"5 % 0", ErrDivisionByZero},
+		{"1 << -1", ErrInvalidOperand},
+		{"~1.5", ErrInvalidOperand},
+		{"sqrt(-1)", ErrDomain},
+		{"ln(0)", ErrDomain},
+		{"pow(10, 400)", ErrOverflow},
+		{"10 ** 400", ErrOverflow},
+		{"x + 1", ErrUnknownIdentifier},
+	}
+	for _, e := range exprs {
+		_, err := ParseAndExec(e.Expr)
+		var ee *EvalError
+		if !errors.As(err, &ee) || ee.Kind != e.Kind || !errors.Is(err, e.Kind) {
+			t.Error(err, e, " EvalError")
+		}
+		if errors.Is(err, ErrSyntax) {
+			t.Error(err, e, " shouldn't be a syntax error")
+		}
+	}
+
+	ex, _ := Compile("2 *\n  y")
+	_, err := ex.Eval(nil)
+	var ee *EvalError
+	if !errors.As(err, &ee) || ee.Token != "y" || ee.Offset != 6 || ee.Line != 2 || ee.Column != 3 {
+		t.Error(err, " Expression.Eval EvalError:", ee)
+	}
+
+	if _, err := ParseAndExecChecked("9223372036854775807 + 1"); !errors.Is(err, ErrOverflow) {
+		t.Error(err, " ParseAndExecChecked overflow")
+	}
+	if _, err := ParseAndExecBig("1 / (2 - 2)"); !errors.Is(err, ErrDivisionByZero) {
+		t.Error(err, " ParseAndExecBig division by zero")
+	}
+	if _, err := ParseAndExecWithConfig("10 * 10", Config{MaxAbsResult: 50}); !errors.Is(err, ErrOverflow) {
+		t.Error(err, " MaxAbsResult overflow")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if errs := Validate("max(1, 2) + x"); errs != nil {
+		t.Error(errs, " Validate valid expression")
+	}
+	errs := Validate("1 $+ 2 # * foo(1) + sqrt(1, 2) + bar()")
+	kinds := []ErrorKind{ErrSyntax, ErrSyntax, ErrUnknownIdentifier, ErrSyntax, ErrUnknownIdentifier}
+	if len(errs) != len(kinds) {
+		t.Fatal(errs, " Validate: want ", len(kinds), " errors")
+	}
+	for i, err := range errs {
+		if !errors.Is(err, kinds[i]) {
+			t.Error(i, err, " Validate kind:", kinds[i])
+		}
+	}
+	if errs := Validate("(1 + 2"); len(errs) != 1 {
+		t.Error(errs, " Validate syntax error")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	type U struct {
+		Expr string
+		Cfg  Config
+	}
+	deep := strings.Repeat("(", 2000) + "1" + strings.Repeat(")", 2000)
+	parseErrs := []U{
+		{deep, Config{}},
+		{"((((1))))", Config{MaxDepth: 3}},
+		{"----1", Config{MaxDepth: 3}},
+		{"2 ** 2 ** 2 ** 2 ** 2", Config{MaxDepth: 3}},
+		{"max(1, max(2, max(3, 4)))", Config{MaxDepth: 3}},
+		{"1 + 2 + 3", Config{MaxNodes: 4}},
+		{"x ? -1 : 2", Config{MaxNodes: 4}},
+		{strings.Repeat("1 ? 1 : ", 2000) + "0", Config{}},
+		{strings.Repeat("1 ? ", 60) + "1" + strings.Repeat(" : 0", 60), Config{MaxDepth: 50}},
+	}
+	for _, e := range parseErrs {
+		_, err := EvalWithContext(context.Background(), e.Expr, e.Cfg)
+		var pe *ParseError
+		if !errors.As(err, &pe) || !errors.Is(err, ErrLimit) {
+			t.Error(err, e.Cfg, " want an ErrLimit ParseError")
+		}
+	}
+	if r, err := EvalWithContext(context.Background(), "((1 + 2)) * -3", Config{MaxDepth: 3, MaxNodes: 7, MaxSteps: 7}); err != nil || r.Value != -9 {
+		t.Error(err, r, " within the limits")
+	}
+
+	_, err := EvalWithContext(context.Background(), "1 + 2 * 3", Config{MaxSteps: 4})
+	var ee *EvalError
+	if !errors.As(err, &ee) || !errors.Is(err, ErrLimit) {
+		t.Error(err, " want an ErrLimit EvalError")
+	}
+	if _, err := EvalWithContext(context.Background(), "0 && (1 + 2 + 3)", Config{MaxSteps: 3}); err != nil {
+		t.Error(err, " short-circuit steps")
+	}
+	ex, err := CompileWithConfig("1 + 2 * 3", Config{MaxSteps: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ex.Bytecode().Run(nil); !errors.Is(err, ErrLimit) {
+		t.Error(err, " Bytecode MaxSteps")
+	}
+	ex, err = CompileWithConfig("noerr("+strings.Repeat("1 + ", 10)+"1)", Config{MaxSteps: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ex.Eval(nil); !errors.Is(err, ErrLimit) {
+		t.Error(err, " noerr MaxSteps")
+	}
+	if _, err := ex.Bytecode().Run(nil); !errors.Is(err, ErrLimit) {
+		t.Error(err, " Bytecode noerr MaxSteps")
+	}
+	if r, err := EvalWithContext(context.Background(), "noerr(1 / 0) + 1", Config{MaxSteps: 10}); err != nil || r.Value != 1 {
+		t.Error(err, r, " noerr within the limits")
+	}
+
+	_ = RegFunction("limitdouble", 1, func(expr ...ExprAST) float64 {
+		return ExprASTResult(expr[0]) * 2
+	})
+	defer Unregister("limitdouble")
+	ex, err = CompileWithConfig("limitdouble("+strings.Repeat("1 + ", 10)+"1)", Config{MaxSteps: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ex.Eval(nil); !errors.Is(err, ErrLimit) {
+		t.Error(err, " RegFunction MaxSteps")
+	}
+	if _, err := ex.Bytecode().Run(nil); !errors.Is(err, ErrLimit) {
+		t.Error(err, " Bytecode RegFunction MaxSteps")
+	}
+	if r, err := EvalWithContext(context.Background(), "limitdouble(2 > 1)", Config{TrueValue: -1}); err != nil || r.Value != -2 {
+		t.Error(err, r, " RegFunction argument TrueValue")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = EvalWithContext(ctx, "1 + 2", Config{})
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Error(err, " canceled context")
+	}
+}
@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Expression is an expression parsed once by Compile,
+// it can be evaluated many times, also concurrently, with Eval
+type Expression struct {
+	// Root of the AST, it must not be modified
+	Root ExprAST
+
+	source string
+	cfg    Config
+}
+
+// Compile is a Top level function
+// tokenizes and parses s once, so that evaluating it again only walks the AST
+func Compile(s string) (*Expression, error) {
+	return CompileWithConfig(s, Config{})
+}
+
+// CompileWithConfig is like Compile, with the optional behaviour set in cfg
+func CompileWithConfig(s string, cfg Config) (*Expression, error) {
+	toks, err := ParseWithConfig(s, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ast := NewASTWithConfig(toks, s, cfg)
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	return &Expression{Root: ar, source: s, cfg: cfg}, nil
+}
+
+// Eval walks the compiled AST, the variables are looked up in vars
+// err is not nil if an arithmetic runtime error occurs,
+// or lists all the variables missing from vars with their positions
+func (e *Expression) Eval(vars map[string]float64) (float64, error) {
+	r, err := (&evaluator{cfg: e.cfg, vars: vars}).run(e.Root)
+	return r, e.evalError(err, vars)
+}
+
+// EvalWithContext is like Eval, the evaluation stops with an ErrCanceled
+// EvalError once ctx is done
+func (e *Expression) EvalWithContext(ctx context.Context, vars map[string]float64) (float64, error) {
+	r, err := (&evaluator{cfg: e.cfg, vars: vars, ctx: ctx}).run(e.Root)
+	return r, e.evalError(err, vars)
+}
+
+// evalError completes an error of the evaluation with the source positions
+func (e *Expression) evalError(err error, vars map[string]float64) error {
+	if isUndefinedVariable(err) {
+		if u := e.undefinedError(vars); u != nil {
+			return u
+		}
+	}
+	if ee, ok := err.(*EvalError); ok && ee.Offset >= 0 {
+		c := *ee
+		c.Line, c.Column = position(e.source, c.Offset)
+		return &c
+	}
+	return err
+}
+
+// undefinedError lists the variables of the expression missing from vars,
+// it is nil if there is none
+func (e *Expression) undefinedError(vars map[string]float64) *EvalError {
+	first := -1
+	var names []string
+	seen := map[string]bool{}
+	var pos strings.Builder
+	var walk func(expr ExprAST)
+	walk = func(expr ExprAST) {
+		if v, ok := expr.(VariableExprAST); ok {
+			if _, ok := vars[v.Name]; ok {
+				return
+			}
+			if _, ok := lookupConst(v.Name); ok {
+				return
+			}
+			if first < 0 {
+				first = v.Offset
+			}
+			if !seen[v.Name] {
+				seen[v.Name] = true
+				names = append(names, v.Name)
+			}
+			fmt.Fprintf(&pos, "\n'%s' pos [%v:]%s\n%s",
+				v.Name,
+				v.Offset,
+				didYouMean(v.Name, identNames(vars)),
+				ErrPos(e.source, v.Offset))
+		}
+		for _, c := range Children(expr) {
+			walk(c)
+		}
+	}
+	walk(e.Root)
+	if first < 0 {
+		return nil
+	}
+	err := undefinedVariableError(names[0], first)
+	err.Msg = fmt.Sprintf("undefined variables: %s%s", strings.Join(names, ", "), pos.String())
+	err.Line, err.Column = position(e.source, first)
+	return err
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// ExpressionCache is an LRU cache of the expressions compiled with Compile,
+// keyed by source. it is safe for concurrent use
+type ExpressionCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of *Expression, the most recently used first
+	items map[string]*list.Element
+}
+
+// NewExpressionCache returns a cache holding at most size expressions
+func NewExpressionCache(size int) *ExpressionCache {
+	if size < 1 {
+		size = 1
+	}
+	return &ExpressionCache{
+		size:  size,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// Compile is like the Top level Compile, returning the cached expression of s if there is one.
+// errors are not cached
+func (c *ExpressionCache) Compile(s string) (*Expression, error) {
+	c.mu.Lock()
+	if el, ok := c.items[s]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*Expression), nil
+	}
+	c.mu.Unlock()
+
+	e, err := Compile(s)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[s]; ok {
+		// compiled concurrently
+		c.order.MoveToFront(el)
+		return el.Value.(*Expression), nil
+	}
+	c.items[s] = c.order.PushFront(e)
+	if c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*Expression).source)
+	}
+	return e, nil
+}
+
+// Len returns the number of cached expressions
+func (c *ExpressionCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
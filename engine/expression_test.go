@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	e, err := Compile("x * 2 + y")
+	if err != nil {
+		t.Fatal(err, " Compile")
+	}
+	if e.String() != "x * 2 + y" {
+		t.Error(e.String(), " Expression.String")
+	}
+	for i := 0; i < 10; i++ {
+		r, err := e.Eval(map[string]float64{"x": float64(i), "y": 1})
+		if err != nil || r != float64(i*2+1) {
+			t.Error(err, i, " Expression.Eval:", r)
+		}
+	}
+	if _, err := e.Eval(nil); err == nil {
+		t.Error("undefined variables should be an error")
+	}
+
+	e, err = CompileWithConfig("1 < 2", Config{TrueValue: -1})
+	if err != nil {
+		t.Fatal(err, " CompileWithConfig")
+	}
+	if r, err := e.Eval(nil); err != nil || r != -1 {
+		t.Error(err, " Expression.Eval TrueValue:", r)
+	}
+
+	for _, s := range []string{"1+", "(1", "1 2"} {
+		if _, err := Compile(s); err == nil {
+			t.Error(s, " this is error expr!")
+		}
+	}
+	e, _ = Compile("1/0")
+	if _, err := e.Eval(nil); err == nil {
+		t.Error("1/0 should be an error")
+	}
+}
+
+func TestExpressionCache(t *testing.T) {
+	c := NewExpressionCache(2)
+	a, err := c.Compile("x + 1")
+	if err != nil {
+		t.Fatal(err, " ExpressionCache.Compile")
+	}
+	if a2, _ := c.Compile("x + 1"); a2 != a {
+		t.Error("the cached expression should be returned")
+	}
+	c.Compile("x + 2")
+	c.Compile("x + 1") // x + 2 is now the least recently used
+	c.Compile("x + 3")
+	if c.Len() != 2 {
+		t.Error(c.Len(), " ExpressionCache.Len want 2")
+	}
+	if a2, _ := c.Compile("x + 1"); a2 != a {
+		t.Error("the recently used expression should stay cached")
+	}
+	if _, err := c.Compile("x +"); err == nil || c.Len() != 2 {
+		t.Error(err, " errors should not be cached")
+	}
+
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			for j := 0; j < 50; j++ {
+				e, err := c.Compile("x * " + string(rune('1'+j%5)))
+				if err != nil {
+					t.Error(err, " ExpressionCache.Compile")
+				} else if r, err := e.Eval(map[string]float64{"x": 2}); err != nil || r != float64(2*(1+j%5)) {
+					t.Error(err, " Expression.Eval:", r)
+				}
+			}
+			done <- true
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"strings"
+)
+
+// FormatOptions are the options of FormatWith
+type FormatOptions struct {
+	// FullParens parenthesizes every operation, like ExprASTToString
+	FullParens bool
+}
+
+// Format is a Top level function
+// renders the AST as source that parses back to it,
+// with parentheses only where precedence and associativity require them,
+// e.g. "((1+2)*3) * (4)" is "(1 + 2) * 3 * 4"
+func Format(expr ExprAST) string {
+	return FormatWith(expr, FormatOptions{})
+}
+
+// FormatWith is like Format, with the options set in opts
+func FormatWith(expr ExprAST, opts FormatOptions) string {
+	if opts.FullParens {
+		return ExprASTToString(expr)
+	}
+	var b strings.Builder
+	formatNode(&b, expr)
+	return b.String()
+}
+
+// isNegativeNumber reports whether expr is a number written with a leading '-',
+// which reads as a prefix minus: it needs parentheses where that binds too loosely
+func isNegativeNumber(expr ExprAST) bool {
+	n, ok := expr.(NumberExprAST)
+	return ok && strings.HasPrefix(ExprASTToString(n), "-")
+}
+
+// formatNode writes expr without enclosing parentheses
+func formatNode(b *strings.Builder, expr ExprAST) {
+	switch e := expr.(type) {
+	case BinaryExprAST:
+		prec := precedence[e.Op]
+		if u, ok := e.Lhs.(UnaryExprAST); ok && !u.Postfix && e.Op == "**" || e.Op == "**" && isNegativeNumber(e.Lhs) {
+			// "(-2) ** 2", a prefix operator would take the "**"
+			b.WriteString("(")
+			formatNode(b, e.Lhs)
+			b.WriteString(")")
+		} else {
+			formatOperand(b, e.Lhs, func(p int) bool {
+				return p < prec || p == prec && rightAssoc[e.Op]
+			})
+		}
+		b.WriteString(" " + e.Op + " ")
+		formatOperand(b, e.Rhs, func(p int) bool {
+			return p < prec || p == prec && !rightAssoc[e.Op]
+		})
+	case UnaryExprAST:
+		if e.Postfix {
+			// the operand of the factorial is a number, a variable or a call
+			switch e.Operand.(type) {
+			case NumberExprAST, VariableExprAST, FunCallerExprAST:
+				if !isNegativeNumber(e.Operand) {
+					formatNode(b, e.Operand)
+					b.WriteString(e.Op)
+					return
+				}
+			case UnaryExprAST:
+				if e.Operand.(UnaryExprAST).Postfix {
+					formatNode(b, e.Operand)
+					b.WriteString(e.Op)
+					return
+				}
+			}
+			b.WriteString("(")
+			formatNode(b, e.Operand)
+			b.WriteString(")" + e.Op)
+			return
+		}
+		// the operand of a prefix operator takes the "**" operators,
+		// and "- -x" is written "--x"
+		b.WriteString(e.Op)
+		formatOperand(b, e.Operand, func(p int) bool { return p < precedence["**"] })
+	case ConditionalExprAST:
+		if _, ok := e.Cond.(ConditionalExprAST); ok {
+			b.WriteString("(")
+			formatNode(b, e.Cond)
+			b.WriteString(")")
+		} else {
+			formatNode(b, e.Cond)
+		}
+		b.WriteString(" ? ")
+		formatNode(b, e.Then)
+		b.WriteString(" : ")
+		formatNode(b, e.Else)
+	case FunCallerExprAST:
+		b.WriteString(e.Name + "(")
+		for i, a := range e.Arg {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			formatNode(b, a)
+		}
+		b.WriteString(")")
+	case AssignExprAST:
+		b.WriteString(e.Name + " = ")
+		formatNode(b, e.Value)
+	default:
+		b.WriteString(ExprASTToString(expr))
+	}
+}
+
+// formatOperand writes the operand expr of an operator,
+// parenthesized if it is a conditional or a binary operation for which needParens
+// of its precedence is true
+func formatOperand(b *strings.Builder, expr ExprAST, needParens func(prec int) bool) {
+	paren := false
+	switch e := expr.(type) {
+	case ConditionalExprAST:
+		paren = true
+	case BinaryExprAST:
+		paren = needParens(precedence[e.Op])
+	}
+	if paren {
+		b.WriteString("(")
+	}
+	formatNode(b, expr)
+	if paren {
+		b.WriteString(")")
+	}
+}
@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		Want string
+	}{
+		{"((1+2)) * (3*4)", "(1 + 2) * (3 * 4)"},
+		{"((1+2) * 3) * 4", "(1 + 2) * 3 * 4"},
+		{"1 - (2 - 3)", "1 - (2 - 3)"},
+		{"(1 - 2) - 3", "1 - 2 - 3"},
+		{"2 ** (3 ** 2)", "2 ** 3 ** 2"},
+		{"(2 ** 3) ** 2", "(2 ** 3) ** 2"},
+		{"-(x + 1) * -y", "-(x + 1) * -y"},
+		{"-(2 ** 2)", "-2 ** 2"},
+		{"(-2) ** 2", "(-2) ** 2"},
+		{"2 ** -x", "2 ** -x"},
+		{"-(3!)", "-3!"},
+		{"(-3)! + (x + 1)! + max(1, 2)!!", "(-3)! + (x + 1)! + max(1, 2)!!"},
+		{"-(x * 2)", "-(x * 2)"},
+		{"+x", "+x"},
+		{"--x", "--x"},
+		{"~(1 | 2) & !x", "~(1 | 2) & !x"},
+		{"max(1, (2 + 3), sqrt((4)))", "max(1, 2 + 3, sqrt(4))"},
+		{"(a > 1) && (b < 2 || c)", "a > 1 && (b < 2 || c)"},
+		{"(x ? 1 : 2) + 3", "(x ? 1 : 2) + 3"},
+		{"x ? (y ? 1 : 2) : (z ? 3 : 4)", "x ? y ? 1 : 2 : z ? 3 : 4"},
+		{"(x ? y : z) ? 1 : 2", "(x ? y : z) ? 1 : 2"},
+		{"(a ?: b) ?: c", "a ?: b ?: c"},
+		{"0xFF & (1e3 % 7)", "0xFF & 1e3 % 7"},
+		{"(1 << 2) + 3", "(1 << 2) + 3"},
+	}
+	for _, e := range exprs {
+		ar := parseExpr(t, e.Expr)
+		s := Format(ar)
+		if s != e.Want {
+			t.Error(e, " Format:", s)
+		}
+		// the output parses back to the same AST
+		if back := parseExpr(t, s); ExprASTToString(back) != ExprASTToString(ar) {
+			t.Error(e, " Format doesn't parse back:", ExprASTToString(back))
+		}
+		if s := FormatWith(ar, FormatOptions{FullParens: true}); s != ExprASTToString(ar) {
+			t.Error(e, " FormatWith FullParens:", s)
+		}
+	}
+}
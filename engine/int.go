@@ -0,0 +1,330 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// IntMode selects the integer arithmetic of ParseAndExecInt
+type IntMode int
+
+const (
+	// Int64Checked is int64 arithmetic where an overflow is an error
+	Int64Checked IntMode = iota
+	// Int64Wrapping is int64 arithmetic wrapping around on overflow like Go,
+	// "9223372036854775807 + 1" is -9223372036854775808
+	Int64Wrapping
+	// Uint64Wrapping is uint64 arithmetic wrapping around on overflow like Go,
+	// "0 - 1" is 18446744073709551615
+	Uint64Wrapping
+)
+
+func (m IntMode) String() string {
+	switch m {
+	case Int64Checked:
+		return "int64 checked"
+	case Int64Wrapping:
+		return "int64 wrapping"
+	case Uint64Wrapping:
+		return "uint64 wrapping"
+	}
+	return fmt.Sprintf("IntMode(%d)", int(m))
+}
+
+// IntResult is a result of ParseAndExecInt, its 64 bits are read in Mode
+type IntResult struct {
+	Bits uint64
+	Mode IntMode
+}
+
+// Int64 is the value in a signed mode, the bits as an int64 in Uint64Wrapping
+func (r IntResult) Int64() int64 {
+	return int64(r.Bits)
+}
+
+// Uint64 is the value in Uint64Wrapping, the bits as a uint64 in a signed mode
+func (r IntResult) Uint64() uint64 {
+	return r.Bits
+}
+
+func (r IntResult) String() string {
+	if r.Mode == Uint64Wrapping {
+		return strconv.FormatUint(r.Bits, 10)
+	}
+	return strconv.FormatInt(int64(r.Bits), 10)
+}
+
+// ParseAndExecInt is a Top level function
+// Analytical expression and execution in 64-bit integer arithmetic,
+// mode selects signed or unsigned, and wrapping around or an error on overflow:
+// "9223372036854775807 + 1" is an overflow error with Int64Checked
+// and -9223372036854775808 with Int64Wrapping.
+// literals must be integers in the range of the mode, / and % truncate toward zero,
+// and shifts by 64 bits or more give 0, or -1 for >> of a negative int64, like Go.
+// comparisons and logical operators return 1 or 0, functions and variables are errors
+func ParseAndExecInt(s string, mode IntMode) (IntResult, error) {
+	if mode < Int64Checked || mode > Uint64Wrapping {
+		return IntResult{}, errors.New(fmt.Sprintf("ParseAndExecInt: unknown %v", mode))
+	}
+	toks, err := Parse(s)
+	if err != nil {
+		return IntResult{}, err
+	}
+	ast := NewAST(toks, s)
+	if ast.Err != nil {
+		return IntResult{}, ast.Err
+	}
+	ast.uintLiterals = mode == Uint64Wrapping
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		return IntResult{}, ast.Err
+	}
+	v, err := (&intEvaluator{mode: mode}).eval(ar)
+	if err != nil {
+		return IntResult{}, err
+	}
+	return IntResult{Bits: v, Mode: mode}, nil
+}
+
+// intEvaluator evaluates an AST on the 64 bits of an IntMode
+type intEvaluator struct {
+	mode IntMode
+}
+
+func (ev *intEvaluator) signed() bool {
+	return ev.mode != Uint64Wrapping
+}
+
+func (ev *intEvaluator) eval(expr ExprAST) (uint64, error) {
+	switch expr.(type) {
+	case BinaryExprAST:
+		ast := expr.(BinaryExprAST)
+		l, err := ev.eval(ast.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		if ast.Op == "?:" {
+			if l != 0 {
+				return l, nil
+			}
+			return ev.eval(ast.Rhs)
+		}
+		if ast.Op == "&&" && l == 0 || ast.Op == "||" && l != 0 {
+			return intBool(l != 0), nil
+		}
+		r, err := ev.eval(ast.Rhs)
+		if err != nil {
+			return 0, err
+		}
+		return ev.binary(ast.Op, l, r)
+	case UnaryExprAST:
+		u := expr.(UnaryExprAST)
+		if n, ok := u.Operand.(NumberExprAST); ok && u.Op == "-" && !u.Postfix {
+			// -9223372036854775808 is an int64 literal
+			return ev.literal(n, true)
+		}
+		v, err := ev.eval(u.Operand)
+		if err != nil {
+			return 0, err
+		}
+		return ev.unary(u, v)
+	case ConditionalExprAST:
+		c := expr.(ConditionalExprAST)
+		cond, err := ev.eval(c.Cond)
+		if err != nil {
+			return 0, err
+		}
+		if cond != 0 {
+			return ev.eval(c.Then)
+		}
+		return ev.eval(c.Else)
+	case NumberExprAST:
+		return ev.literal(expr.(NumberExprAST), false)
+	case VariableExprAST:
+		return 0, errors.New(
+			fmt.Sprintf("variable '%s' is not supported in ParseAndExecInt", expr.(VariableExprAST).Name))
+	case FunCallerExprAST:
+		return 0, errors.New(
+			fmt.Sprintf("function '%s' is not supported in ParseAndExecInt", expr.(FunCallerExprAST).Name))
+	}
+	return 0, nil
+}
+
+// literal is the value of n, negated if neg, it must be in the range of the mode
+func (ev *intEvaluator) literal(n NumberExprAST, neg bool) (uint64, error) {
+	if n.Str == "" {
+		// built without source text
+		n.Str = Float64ToStr(n.Val)
+	}
+	v, ok := new(big.Rat).SetString(n.Str)
+	if !ok || !v.IsInt() {
+		return 0, errors.New(
+			fmt.Sprintf("literal %s is not an integer in ParseAndExecInt", n.Str))
+	}
+	i := v.Num()
+	if neg {
+		i.Neg(i)
+	}
+	switch {
+	case ev.signed() && i.IsInt64():
+		return uint64(i.Int64()), nil
+	case !ev.signed() && i.IsUint64():
+		return i.Uint64(), nil
+	case !ev.signed() && neg && i.IsInt64():
+		// wraps around like the negation of a uint64
+		return uint64(i.Int64()), nil
+	}
+	return 0, evalError(ErrOverflow, n.Str,
+		fmt.Sprintf("literal %s overflows %v in ParseAndExecInt", i, ev.mode))
+}
+
+// overflow is the error of an overflow of op, it is nil but in Int64Checked
+func (ev *intEvaluator) overflow(overflows bool, l uint64, op string, r uint64) error {
+	if !overflows || ev.mode != Int64Checked {
+		return nil
+	}
+	return evalError(ErrOverflow, op,
+		fmt.Sprintf("integer overflow in ParseAndExecInt: [%d%s%d]", int64(l), op, int64(r)))
+}
+
+// binary applies the operator op to the evaluated operands l and r
+func (ev *intEvaluator) binary(op string, l, r uint64) (uint64, error) {
+	sl, sr := int64(l), int64(r)
+	switch op {
+	case "+":
+		v := l + r
+		return v, ev.overflow(sr > 0 && int64(v) < sl || sr < 0 && int64(v) > sl, l, op, r)
+	case "-":
+		v := l - r
+		return v, ev.overflow(sr > 0 && int64(v) > sl || sr < 0 && int64(v) < sl, l, op, r)
+	case "*":
+		v := l * r
+		return v, ev.overflow(sl != 0 && (int64(v)/sl != sr ||
+			sl == -1 && sr == math.MinInt64 || sr == -1 && sl == math.MinInt64), l, op, r)
+	case "/", "%":
+		if r == 0 {
+			return 0, evalError(ErrDivisionByZero, op,
+				fmt.Sprintf("violation of arithmetic specification: a division by zero in ParseAndExecInt: [%s%s%s]",
+					ev.format(l),
+					op,
+					ev.format(r)))
+		}
+		if !ev.signed() {
+			if op == "%" {
+				return l % r, nil
+			}
+			return l / r, nil
+		}
+		if op == "%" {
+			return uint64(sl % sr), nil
+		}
+		return uint64(sl / sr), ev.overflow(sl == math.MinInt64 && sr == -1, l, op, r)
+	case "**":
+		if ev.signed() && sr < 0 {
+			return 0, evalError(ErrInvalidOperand, op,
+				fmt.Sprintf("negative exponent in ParseAndExecInt: [%d**%d]", sl, sr))
+		}
+		// exponentiation by squaring, each product is checked
+		v, b := uint64(1), l
+		for e := r; e > 0; e >>= 1 {
+			var err error
+			if e&1 == 1 {
+				if v, err = ev.binary("*", v, b); err != nil {
+					return 0, ev.overflow(true, l, op, r)
+				}
+			}
+			if e > 1 {
+				if b, err = ev.binary("*", b, b); err != nil {
+					return 0, ev.overflow(true, l, op, r)
+				}
+			}
+		}
+		return v, nil
+	case "^":
+		return l ^ r, nil
+	case "&":
+		return l & r, nil
+	case "|":
+		return l | r, nil
+	case ">>", "<<":
+		if ev.signed() && sr < 0 {
+			return 0, evalError(ErrInvalidOperand, op,
+				fmt.Sprintf("violation of arithmetic specification: a negative shift count in ParseAndExecInt: [%d%s%d]",
+					sl,
+					op,
+					sr))
+		}
+		if op == ">>" {
+			if ev.signed() {
+				return uint64(sl >> r), nil
+			}
+			return l >> r, nil
+		}
+		v := l << r
+		return v, ev.overflow(r >= 64 && l != 0 || r < 64 && int64(v)>>r != sl, l, op, r)
+	case ">", "<", ">=", "<=", "==", "!=":
+		c := 0
+		switch {
+		case ev.signed() && sl < sr, !ev.signed() && l < r:
+			c = -1
+		case l != r:
+			c = 1
+		}
+		return intBool(op == ">" && c > 0 || op == "<" && c < 0 ||
+			op == ">=" && c >= 0 || op == "<=" && c <= 0 ||
+			op == "==" && c == 0 || op == "!=" && c != 0), nil
+	case "&&", "||":
+		return intBool(r != 0), nil
+	}
+	return 0, errors.New(
+		fmt.Sprintf("unknown operator '%s' in ParseAndExecInt", op))
+}
+
+// unary applies the operator of u to the evaluated operand v
+func (ev *intEvaluator) unary(u UnaryExprAST, v uint64) (uint64, error) {
+	switch {
+	case u.Postfix:
+		if ev.signed() && int64(v) < 0 {
+			return 0, evalError(ErrDomain, u.Op,
+				fmt.Sprintf("domain error: the factorial of %d is undefined in ParseAndExecInt", int64(v)))
+		}
+		if v >= 66 && ev.mode != Int64Checked {
+			// 2**64 divides the factorial
+			return 0, nil
+		}
+		r := uint64(1)
+		for i := uint64(2); i <= v; i++ {
+			var err error
+			if r, err = ev.binary("*", r, i); err != nil {
+				return 0, evalError(ErrOverflow, u.Op,
+					fmt.Sprintf("integer overflow in ParseAndExecInt: [%d!]", v))
+			}
+		}
+		return r, nil
+	case u.Op == "-":
+		return -v, ev.overflow(int64(v) == math.MinInt64, 0, u.Op, v)
+	case u.Op == "+":
+		return v, nil
+	case u.Op == "~":
+		return ^v, nil
+	case u.Op == "!":
+		return intBool(v == 0), nil
+	}
+	return 0, errors.New(
+		fmt.Sprintf("unknown operator '%s' in ParseAndExecInt", u.Op))
+}
+
+// format writes v in the mode
+func (ev *intEvaluator) format(v uint64) string {
+	return IntResult{Bits: v, Mode: ev.mode}.String()
+}
+
+func intBool(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseAndExecInt(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		Mode IntMode
+		R    string
+	}{
+		{"9223372036854775807 + 0", Int64Checked, "9223372036854775807"},
+		{"-9223372036854775808", Int64Checked, "-9223372036854775808"},
+		{"-9223372036854775807 - 1", Int64Checked, "-9223372036854775808"},
+		{"7 / 2 + -7 / 2 + 7 % 3", Int64Checked, "1"},
+		{"3 ** 39", Int64Checked, "4052555153018976267"},
+		{"20! + (2 > 1) + !0", Int64Checked, "2432902008176640002"},
+		{"1 << 62", Int64Checked, "4611686018427387904"},
+		{"-1 >> 70", Int64Checked, "-1"},
+		{"9223372036854775807 + 1", Int64Wrapping, "-9223372036854775808"},
+		{"-9223372036854775808 - 1", Int64Wrapping, "9223372036854775807"},
+		{"-9223372036854775808 / -1", Int64Wrapping, "-9223372036854775808"},
+		{"3 ** 40", Int64Wrapping, "-6289078614652622815"},
+		{"1 << 64", Int64Wrapping, "0"},
+		{"70!", Int64Wrapping, "0"},
+		{"0 - 1", Uint64Wrapping, "18446744073709551615"},
+		{"-1", Uint64Wrapping, "18446744073709551615"},
+		{"0xFFFFFFFFFFFFFFFF + 2", Uint64Wrapping, "1"},
+		{"18446744073709551615 / 2", Uint64Wrapping, "9223372036854775807"},
+		{"~0 >> 63", Uint64Wrapping, "1"},
+		{"(0 - 1) > 1", Uint64Wrapping, "1"},
+		{"-1 > 1", Int64Checked, "0"},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecInt(e.Expr, e.Mode)
+		if err != nil || r.String() != e.R {
+			t.Error(err, e, " ParseAndExecInt:", r)
+		}
+	}
+	if r, _ := ParseAndExecInt("0 - 1", Uint64Wrapping); r.Uint64() != 1<<64-1 || r.Int64() != -1 {
+		t.Error(r, " IntResult Uint64 Int64")
+	}
+
+	errs := []struct {
+		Expr string
+		Mode IntMode
+		Kind ErrorKind
+	}{
+		{"9223372036854775807 + 1", Int64Checked, ErrOverflow},
+		{"-9223372036854775808 - 1", Int64Checked, ErrOverflow},
+		{"-9223372036854775808 * -1", Int64Checked, ErrOverflow},
+		{"-9223372036854775808 / -1", Int64Checked, ErrOverflow},
+		{"-(-9223372036854775807 - 1)", Int64Checked, ErrOverflow},
+		{"3 ** 40", Int64Checked, ErrOverflow},
+		{"1 << 63", Int64Checked, ErrOverflow},
+		{"21!", Int64Checked, ErrOverflow},
+		{"9223372036854775808", Int64Wrapping, ErrOverflow},
+		{"18446744073709551616", Uint64Wrapping, ErrOverflow},
+		{"0xFFFFFFFFFFFFFFFF", Int64Checked, ErrOverflow},
+		{"1 / 0", Uint64Wrapping, ErrDivisionByZero},
+		{"1 % 0", Int64Wrapping, ErrDivisionByZero},
+		{"1 << -1", Int64Wrapping, ErrInvalidOperand},
+		{"2 ** -1", Int64Checked, ErrInvalidOperand},
+		{"(-1)!", Int64Checked, ErrDomain},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExecInt(e.Expr, e.Mode); !errors.Is(err, e.Kind) {
+			t.Error(err, e, " ParseAndExecInt")
+		}
+	}
+	for _, e := range []string{"1.5 + 1", "x + 1", "abs(1)"} {
+		if _, err := ParseAndExecInt(e, Int64Wrapping); err == nil || !strings.Contains(err.Error(), "ParseAndExecInt") {
+			t.Error(err, e, " ParseAndExecInt")
+		}
+	}
+	if _, err := ParseAndExecInt("1", IntMode(9)); err == nil {
+		t.Error("ParseAndExecInt unknown mode")
+	}
+}
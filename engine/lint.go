@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// Diagnostic is a warning about the source of an expression
+type Diagnostic struct {
+	// Offset of the operator the warning is about
+	Offset  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("pos [%v:]: %s", d.Offset, d.Message)
+}
+
+// confusedPrecedence lists the operators that are commonly expected to bind
+// the other way round: the second operator binds tighter than the first
+var confusedPrecedence = map[[2]string]bool{
+	// 2 + 3 << 1 is (2 + 3) << 1
+	{"<<", "+"}: true, {"<<", "-"}: true,
+	{">>", "+"}: true, {">>", "-"}: true,
+	// 1 & 2 > 1 is 1 & (2 > 1)
+	{"&", ">"}: true, {"&", "<"}: true, {"&", ">="}: true, {"&", "<="}: true, {"&", "=="}: true, {"&", "!="}: true,
+	{"|", ">"}: true, {"|", "<"}: true, {"|", ">="}: true, {"|", "<="}: true, {"|", "=="}: true, {"|", "!="}: true,
+	{"^", ">"}: true, {"^", "<"}: true, {"^", ">="}: true, {"^", "<="}: true, {"^", "=="}: true, {"^", "!="}: true,
+	// 1 | 2 & 3 is 1 | (2 & 3)
+	{"|", "&"}: true, {"|", "^"}: true, {"^", "&"}: true,
+	// a || b && c is a || (b && c)
+	{"||", "&&"}: true,
+}
+
+// WarnPrecedence is a Top level function
+// reports the places in s where operator precedence likely surprises the reader,
+// e.g. "2 + 3 << 1" which is "(2 + 3) << 1".
+// parenthesized operands are never reported, nil is returned if s doesn't parse
+func WarnPrecedence(s string) []Diagnostic {
+	toks, err := Parse(s)
+	if err != nil {
+		return nil
+	}
+	ast := NewAST(toks, s)
+	if ast.Err != nil {
+		return nil
+	}
+	var ds []Diagnostic
+	ast.onBinary = func(op string, offset int, lhsOp, rhsOp string) {
+		for _, inner := range []string{lhsOp, rhsOp} {
+			if confusedPrecedence[[2]string{op, inner}] {
+				ds = append(ds, Diagnostic{
+					Offset: offset,
+					Message: fmt.Sprintf("'%s' binds tighter than '%s', add parentheses to make the grouping explicit",
+						inner,
+						op),
+				})
+			}
+		}
+	}
+	ast.ParseExpression()
+	if ast.Err != nil {
+		return nil
+	}
+	return ds
+}
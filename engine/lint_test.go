@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestWarnPrecedence(t *testing.T) {
+	exprs := []struct {
+		Expr    string
+		Offsets []int
+	}{
+		{"2 + 3 << 1", []int{6}},
+		{"1 << 2 - 1", []int{2}},
+		{"1 & 2 > 1", []int{2}},
+		{"1 | 2 & 3", []int{2}},
+		{"1 + 2 << 3 | 4 & 5", []int{6, 11}},
+		{"(2 + 3) << 1", nil},
+		{"2 + (3 << 1)", nil},
+		{"1 & (2 > 1)", nil},
+		{"1 + 2 * 3", nil},
+		{"1 <<", nil},
+	}
+	for _, e := range exprs {
+		ds := WarnPrecedence(e.Expr)
+		if len(ds) != len(e.Offsets) {
+			t.Error(e, " WarnPrecedence: ", ds)
+			continue
+		}
+		for i, d := range ds {
+			if d.Offset != e.Offsets[i] || d.Message == "" {
+				t.Error(e, " WarnPrecedence: ", ds)
+			}
+		}
+	}
+}
+
+func TestWarnPrecedenceLogical(t *testing.T) {
+	if ds := WarnPrecedence("a || b && c"); len(ds) != 1 || ds[0].Offset != 2 {
+		t.Error("a || b && c WarnPrecedence: ", ds)
+	}
+	if ds := WarnPrecedence("a || (b && c)"); len(ds) != 0 {
+		t.Error("a || (b && c) WarnPrecedence: ", ds)
+	}
+}
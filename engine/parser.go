@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -14,8 +15,57 @@ const (
 	Operator
 	// ,
 	COMMA
+	// ; or a newline, between the statements of a program
+	SEMICOLON
 )
 
+// RuneClass is the lexical class of a character
+type RuneClass int
+
+const (
+	// not part of the grammar, a symbol error
+	RuneUnknown RuneClass = iota
+	// skipped between tokens
+	RuneWhitespace
+	// starts and continues a Literal
+	RuneDigit
+	// a single character Operator, '>' and '<' may be doubled,
+	// '>', '<', '=' and '!' may be followed by '=', '?' may be followed by ':',
+	// '*', '&' and '|' may be doubled
+	RuneOperator
+	// starts and continues an Identifier
+	RuneIdentifier
+	// ,
+	RuneComma
+)
+
+// TokenizerConfig holds the options of the lexer
+type TokenizerConfig struct {
+	// RuneClassifier classifies the characters of the source,
+	// defaults to DefaultRuneClass.
+	// the lexer reads bytes, so it is only called with ASCII characters
+	// in well-formed input
+	RuneClassifier func(r rune) RuneClass
+}
+
+// DefaultRuneClass is the character classification used by Parse,
+// a custom RuneClassifier can fall back to it
+func DefaultRuneClass(r rune) RuneClass {
+	switch {
+	case r == ' ' || r == '\t' || r == '\n' || r == '\v' || r == '\f' || r == '\r':
+		return RuneWhitespace
+	case '0' <= r && r <= '9':
+		return RuneDigit
+	case 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z':
+		return RuneIdentifier
+	case strings.ContainsRune("()+-*/^&|%<>?:=!~", r):
+		return RuneOperator
+	case r == ',':
+		return RuneComma
+	}
+	return RuneUnknown
+}
+
 type Token struct {
 	// raw characters
 	Tok string
@@ -24,22 +74,70 @@ type Token struct {
 	Flag int
 
 	Offset int
+
+	// source text of the token and the whitespace around it,
+	// only recorded with Config.KeepTrivia.
+	// TrailingTrivia is only set on the last token
+	Raw,
+	LeadingTrivia,
+	TrailingTrivia string
 }
 
+// FlagPercent is the Flag of a '%' Operator read as a percentage with Config.PercentLiterals
+const FlagPercent = 1
+
+// middleDot is U+00B7, read as "*" with Config.MiddleDotMultiply
+const middleDot = "·"
+
 type Parser struct {
 	Source string
 
 	ch     byte
 	offset int
+	cfg    Config
+	// currency symbol seen in front of a number
+	currency string
+
+	// collect, set by Validate, records the unknown symbols in errs
+	// and skips them instead of stopping at the first one
+	collect bool
+	errs    []error
+
+	// statements, set by ExecProgram, reads ';' and newlines as SEMICOLON tokens
+	statements bool
 
 	err error
 }
 
 func Parse(s string) ([]*Token, error) {
+	return ParseWithConfig(s, Config{})
+}
+
+// ParseWithConfig is like Parse, with the lexer options set in cfg
+func ParseWithConfig(s string, cfg Config) ([]*Token, error) {
+	return lex(s, cfg, false)
+}
+
+// lex tokenizes s, the separators of statements too if statements is set
+func lex(s string, cfg Config, statements bool) ([]*Token, error) {
+	if s == "" {
+		return nil, errors.New("empty token")
+	}
+	if dec, arg := cfg.decimalSeparator(), cfg.argumentSeparator(); dec == arg {
+		return nil, errors.New(
+			fmt.Sprintf("config error: '%c' can't be both the decimal and the argument separator", dec))
+	}
+	if group := cfg.groupSeparator(); group != 0 && (group == cfg.decimalSeparator() || group == cfg.ArgumentSeparator) {
+		return nil, errors.New(
+			fmt.Sprintf("config error: '%c' can't be both the grouping and another separator", group))
+	}
 	p := &Parser{
 		Source: s,
 		err:    nil,
 		ch:     s[0],
+		cfg:    cfg,
+
+		statements: statements,
 	}
 	toks := p.parse()
 	if p.err != nil {
@@ -51,15 +149,95 @@ func Parse(s string) ([]*Token, error) {
 func (p *Parser) parse() []*Token {
 	toks := make([]*Token, 0)
 	for {
+		from := p.offset
 		tok := p.nextTok()
+		if p.cfg.PercentLiterals && len(toks) > 0 {
+			markPercent(toks[len(toks)-1], tok)
+		}
 		if tok == nil {
+			if p.cfg.KeepTrivia && len(toks) > 0 && from < len(p.Source) {
+				toks[len(toks)-1].TrailingTrivia = p.Source[from:]
+			}
 			break
 		}
+		if p.cfg.KeepTrivia {
+			p.keepTrivia(tok, from)
+		}
+		if p.cfg.ImplicitMultiply && len(toks) > 0 && implicitMultiply(toks[len(toks)-1], tok) {
+			// without source text, ReconstructExact leaves it out
+			toks = append(toks, &Token{Tok: "*", Type: Operator, Offset: tok.Offset})
+		}
 		toks = append(toks, tok)
 	}
 	return toks
 }
 
+// implicitMultiply reports whether a '*' is implied between prev and next:
+// after a number or ')', before '(' or an identifier, and before a number after ')'.
+// "2(3+4)", "(1+2)(3+4)", "3pi" and "2x" are products, "2 3" and "x(1)" are not
+func implicitMultiply(prev, next *Token) bool {
+	after := prev.Type == Literal || prev.Tok == ")"
+	before := next.Tok == "(" || next.Type == Identifier || next.Type == Literal && prev.Tok == ")"
+	return after && before
+}
+
+// markPercent flags prev as a percentage if it is a '%' that can't be a modulo
+// before next, nil at the end: next doesn't start an operand, it is not
+// a number, a name, '(' or '~'. "10% + 5" is a percentage, "10 % -3" too
+func markPercent(prev, next *Token) {
+	if prev.Type != Operator || prev.Tok != "%" {
+		return
+	}
+	if next == nil || !(next.Type == Literal || next.Type == Identifier || next.Tok == "(" || next.Tok == "~") {
+		prev.Flag = FlagPercent
+	}
+}
+
+// cutEmptyExponent ends the number literal read from start before an 'e'
+// without exponent digits, so that "2e" is 2 * e
+func (p *Parser) cutEmptyExponent(start int) {
+	lit := p.Source[start:p.offset]
+	i := strings.IndexAny(lit, "eE")
+	if i < 0 {
+		return
+	}
+	j := i + 1
+	if j < len(lit) && (lit[j] == '+' || lit[j] == '-') {
+		j++
+	}
+	if j < len(lit) && p.class(lit[j]) == RuneDigit {
+		return
+	}
+	p.offset = start + i
+	p.ch = p.Source[p.offset]
+}
+
+// keepTrivia records the source text of tok, read from offset from
+func (p *Parser) keepTrivia(tok *Token, from int) {
+	start := from
+	for start < len(p.Source) && p.isWhitespace(p.Source[start]) {
+		start++
+	}
+	end := p.offset
+	if end > len(p.Source) {
+		end = len(p.Source)
+	}
+	tok.LeadingTrivia = p.Source[from:start]
+	tok.Raw = p.Source[start:end]
+}
+
+// ReconstructExact is a Top level function
+// returns the source of tokens parsed with Config.KeepTrivia, byte for byte
+func ReconstructExact(toks []*Token) string {
+	var b strings.Builder
+	for _, tok := range toks {
+		b.WriteString(tok.LeadingTrivia)
+		b.WriteString(tok.Raw)
+		b.WriteString(tok.TrailingTrivia)
+	}
+	return b.String()
+}
+
 func (p *Parser) nextTok() *Token {
 	if p.offset >= len(p.Source) || p.err != nil {
 		return nil
@@ -68,32 +246,45 @@ func (p *Parser) nextTok() *Token {
 	for p.isWhitespace(p.ch) && err == nil {
 		err = p.nextCh()
 	}
+	if p.cfg.LineComments && strings.HasPrefix(p.Source[p.offset:], "//") {
+		// the comment runs to the end of the input, of the line in a program
+		i := strings.IndexByte(p.Source[p.offset:], '\n')
+		if !p.statements || i < 0 {
+			p.offset = len(p.Source)
+			return nil
+		}
+		p.offset += i
+		p.ch = '\n'
+	}
+	if len(p.cfg.CurrencySymbols) > 0 && !p.skipCurrency() {
+		return nil
+	}
 	start := p.offset
 	var tok *Token
-	switch p.ch {
-	case
-		'(',
-		')',
-		'+',
-		'-',
-		'*',
-		'/',
-		'^',
-		'&',
-		'|',
-		'%':
+	if p.cfg.MiddleDotMultiply && strings.HasPrefix(p.Source[p.offset:], middleDot) {
 		tok = &Token{
-			Tok:  string(p.ch),
+			Tok:  "*",
 			Type: Operator,
 		}
 		tok.Offset = start
+		p.nextCh()
+		p.nextCh()
+		return tok
+	}
+	switch cls := p.class(p.ch); {
+	case p.statements && (p.ch == ';' || p.ch == '\n'):
+		tok = &Token{
+			Tok:  string(p.ch),
+			Type: SEMICOLON,
+		}
+		tok.Offset = start
 		err = p.nextCh()
-	case
-		'>', '<':
+	case cls == RuneOperator && (p.ch == '>' || p.ch == '<' || p.ch == '=' || p.ch == '!'):
+		// ">>" "<<" and the comparisons ">=" "<=" "==" "!="
 		tokS := string(p.ch)
 		bb, be := p.nextChPeek()
-		if be == nil && string(bb) == tokS {
-			tokS += string(p.ch)
+		if be == nil && (bb == '=' || bb == p.ch && (p.ch == '>' || p.ch == '<')) {
+			tokS += string(bb)
 		}
 		tok = &Token{
 			Tok:  tokS,
@@ -104,29 +295,79 @@ func (p *Parser) nextTok() *Token {
 			p.nextCh()
 		}
 		err = p.nextCh()
-	case
-		'0',
-		'1',
-		'2',
-		'3',
-		'4',
-		'5',
-		'6',
-		'7',
-		'8',
-		'9':
-		for p.isDigitNum(p.ch) && p.nextCh() == nil {
-			if (p.ch == '-' || p.ch == '+') && p.Source[p.offset-1] != 'e' {
+	case cls == RuneOperator && (p.ch == '*' || p.ch == '&' || p.ch == '|'):
+		// "**" is the power operator, "&&" and "||" the logical ones
+		tokS := string(p.ch)
+		if bb, be := p.nextChPeek(); be == nil && bb == p.ch {
+			tokS += string(bb)
+			p.nextCh()
+		}
+		tok = &Token{
+			Tok:  tokS,
+			Type: Operator,
+		}
+		tok.Offset = start
+		err = p.nextCh()
+	case cls == RuneOperator && p.ch == '?':
+		// "?:" is the elvis operator
+		tokS := string(p.ch)
+		if bb, be := p.nextChPeek(); be == nil && bb == ':' {
+			tokS += ":"
+			p.nextCh()
+		}
+		tok = &Token{
+			Tok:  tokS,
+			Type: Operator,
+		}
+		tok.Offset = start
+		err = p.nextCh()
+	case cls == RuneOperator:
+		tok = &Token{
+			Tok:  string(p.ch),
+			Type: Operator,
+		}
+		tok.Offset = start
+		err = p.nextCh()
+	case cls == RuneDigit && p.ch == '0' && p.radixPrefix():
+		// 0x 0o 0b integer literals, parsed by parseNumber
+		for p.nextCh() == nil && (p.isWordChar(p.ch) || p.ch == '_') {
+		}
+		tok = &Token{
+			Tok:  strings.ReplaceAll(p.Source[start:p.offset], "_", ""),
+			Type: Literal,
+		}
+		tok.Offset = start
+	case cls == RuneDigit:
+		for {
+			for p.isDigitNum(p.ch) && p.nextCh() == nil {
+				if (p.ch == '-' || p.ch == '+') && p.Source[p.offset-1] != 'e' && p.Source[p.offset-1] != 'E' {
+					break
+				}
+			}
+			if !(p.cfg.SpaceGrouping && p.skipGroupingSpace(start)) && !p.skipGroupSeparator(start) {
 				break
 			}
 		}
+		if p.cfg.ImplicitMultiply {
+			p.cutEmptyExponent(start)
+		}
+		tokS := strings.ReplaceAll(p.Source[start:p.offset], "_", "")
+		if p.cfg.SpaceGrouping {
+			tokS = strings.ReplaceAll(tokS, " ", "")
+		}
+		if group := p.cfg.groupSeparator(); group != 0 {
+			tokS = strings.ReplaceAll(tokS, string(group), "")
+		}
+		if sep := p.cfg.decimalSeparator(); sep != '.' {
+			tokS = strings.ReplaceAll(tokS, string(sep), ".")
+		}
 		tok = &Token{
-			Tok:  strings.ReplaceAll(p.Source[start:p.offset], "_", ""),
+			Tok:  tokS,
 			Type: Literal,
 		}
 		tok.Offset = start
 
-	case ',':
+	case cls == RuneComma:
 		tok = &Token{
 			Tok:  string(p.ch),
 			Type: COMMA,
@@ -134,29 +375,108 @@ func (p *Parser) nextTok() *Token {
 		tok.Offset = start
 		err = p.nextCh()
 
-	default:
-		if p.isChar(p.ch) {
-			for p.isWordChar(p.ch) && p.nextCh() == nil {
-			}
-			tok = &Token{
-				Tok:  p.Source[start:p.offset],
-				Type: Identifier,
-			}
-			tok.Offset = start
-		} else if p.ch != ' ' {
-			s := fmt.Sprintf("symbol error: unknown '%v', pos [%v:]\n%s",
-				string(p.ch),
-				start,
-				ErrPos(p.Source, start))
-			p.err = errors.New(s)
+	case cls == RuneIdentifier:
+		for p.isWordChar(p.ch) && p.nextCh() == nil {
+		}
+		tok = &Token{
+			Tok:  p.Source[start:p.offset],
+			Type: Identifier,
+		}
+		tok.Offset = start
+	case cls != RuneWhitespace:
+		// the whole rune, not its first byte
+		r, size := utf8.DecodeRuneInString(p.Source[start:])
+		err := newParseError(ErrSyntax, p.Source, start, string(r),
+			fmt.Sprintf("symbol error: unknown '%v', pos [%v:]",
+				string(r),
+				start))
+		if !p.collect {
+			p.err = err
+			break
+		}
+		// Validate, skip the symbol
+		p.errs = append(p.errs, err)
+		p.offset += size - 1
+		if p.nextCh() == nil {
+			return p.nextTok()
 		}
 	}
 	return tok
 }
 
+// skipCurrency moves past a configured currency symbol in front of a number,
+// it reports false if the symbol differs from one seen earlier
+func (p *Parser) skipCurrency() bool {
+	rest := p.Source[p.offset:]
+	for _, sym := range p.cfg.CurrencySymbols {
+		if sym == "" || !strings.HasPrefix(rest, sym) ||
+			len(rest) == len(sym) || rest[len(sym)] < '0' || rest[len(sym)] > '9' {
+			continue
+		}
+		if p.currency != "" && p.currency != sym {
+			p.err = newParseError(ErrSyntax, p.Source, p.offset, sym,
+				fmt.Sprintf("currency error: mixing '%s' and '%s', pos [%v:]",
+					p.currency,
+					sym,
+					p.offset))
+			return false
+		}
+		p.currency = sym
+		p.offset += len(sym)
+		p.ch = p.Source[p.offset]
+		return true
+	}
+	return true
+}
+
+// skipGroupingSpace moves past the spaces between two digit runs
+// in the integer part of the literal read from start,
+// it reports false and leaves the position unchanged if no digit follows
+func (p *Parser) skipGroupingSpace(start int) bool {
+	if strings.IndexAny(p.Source[start:p.offset], string(p.cfg.decimalSeparator())+"eE") >= 0 {
+		return false
+	}
+	i := p.offset
+	for i < len(p.Source) && p.Source[i] == ' ' {
+		i++
+	}
+	if i == p.offset || i >= len(p.Source) || p.Source[i] < '0' || p.Source[i] > '9' {
+		return false
+	}
+	p.offset = i
+	p.ch = p.Source[i]
+	return true
+}
+
+// skipGroupSeparator moves past a grouping separator of the NumberFormat
+// in the integer part of the literal read from start, it must be followed by
+// three digits and no fourth. it reports false and leaves the position unchanged otherwise
+func (p *Parser) skipGroupSeparator(start int) bool {
+	group := p.cfg.groupSeparator()
+	if group == 0 || p.offset >= len(p.Source) || p.ch != group ||
+		strings.IndexAny(p.Source[start:p.offset], string(p.cfg.decimalSeparator())+"eE") >= 0 {
+		return false
+	}
+	i := p.offset + 1
+	for i < len(p.Source) && i-p.offset <= 4 && p.Source[i] >= '0' && p.Source[i] <= '9' {
+		i++
+	}
+	if i-p.offset != 4 {
+		return false
+	}
+	p.offset++
+	p.ch = p.Source[p.offset]
+	return true
+}
+
+// radixPrefix reports whether the next character makes "0" a 0x, 0o or 0b prefix
+func (p *Parser) radixPrefix() bool {
+	bb, be := p.nextChPeek()
+	return be == nil && strings.IndexByte("xXoObB", bb) >= 0
+}
+
 func (p *Parser) nextChPeek() (byte, error) {
 	offset := p.offset + 1
-	fmt.Printf("\np.Source[offset]: %s\n", string(p.Source[offset]))
 	if offset < len(p.Source) {
 		return p.Source[offset], nil
 	}
@@ -172,23 +492,32 @@ func (p *Parser) nextCh() error {
 	return errors.New("EOF")
 }
 
-func (p *Parser) isWhitespace(c byte) bool {
-	return c == ' ' ||
-		c == '\t' ||
-		c == '\n' ||
-		c == '\v' ||
-		c == '\f' ||
-		c == '\r'
+func (p *Parser) class(c byte) RuneClass {
+	if sep := p.cfg.customArgumentSeparator(); sep != 0 {
+		// the separator replaces the commas of the classifier
+		if c == sep {
+			return RuneComma
+		}
+	}
+	cls := DefaultRuneClass(rune(c))
+	if p.cfg.RuneClassifier != nil {
+		cls = p.cfg.RuneClassifier(rune(c))
+	}
+	if cls == RuneComma && p.cfg.customArgumentSeparator() != 0 {
+		return RuneUnknown
+	}
+	return cls
 }
 
-func (p *Parser) isDigitNum(c byte) bool {
-	return '0' <= c && c <= '9' || c == '.' || c == '_' || c == 'e' || c == '-' || c == '+'
+func (p *Parser) isWhitespace(c byte) bool {
+	return p.class(c) == RuneWhitespace && !(p.statements && c == '\n')
 }
 
-func (p *Parser) isChar(c byte) bool {
-	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+func (p *Parser) isDigitNum(c byte) bool {
+	return p.class(c) == RuneDigit || c == p.cfg.decimalSeparator() || c == '_' || c == 'e' || c == 'E' || c == '-' || c == '+'
 }
 
 func (p *Parser) isWordChar(c byte) bool {
-	return p.isChar(c) || '0' <= c && c <= '9'
+	cls := p.class(c)
+	return cls == RuneIdentifier || cls == RuneDigit
 }
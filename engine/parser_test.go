@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestRuneClassifier(t *testing.T) {
+	cfg := Config{}
+	cfg.RuneClassifier = func(r rune) RuneClass {
+		if r == '$' {
+			return RuneIdentifier
+		}
+		return DefaultRuneClass(r)
+	}
+	toks, err := ParseWithConfig("$a + b$1*2", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{
+		{Tok: "$a", Type: Identifier, Offset: 0},
+		{Tok: "+", Type: Operator, Offset: 3},
+		{Tok: "b$1", Type: Identifier, Offset: 5},
+		{Tok: "*", Type: Operator, Offset: 8},
+		{Tok: "2", Type: Literal, Offset: 9},
+	}
+	if len(toks) != len(want) {
+		t.Fatal("ParseWithConfig RuneClassifier tokens: ", len(toks))
+	}
+	for i, tok := range toks {
+		if *tok != want[i] {
+			t.Error(want[i], " ParseWithConfig RuneClassifier: ", *tok)
+		}
+	}
+	if _, err := Parse("$a + 1"); err == nil {
+		t.Error("'$' should be an unknown symbol with DefaultRuneClass")
+	}
+}
+
+func TestReconstructExact(t *testing.T) {
+	exprs := []string{
+		"1+2",
+		"  1 +   2*( 3 -4 )  ",
+		"\t1_000 >>\t2 ",
+		"(\n1 << 3\n) % 5\n",
+	}
+	for _, e := range exprs {
+		toks, err := ParseWithConfig(e, Config{KeepTrivia: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := ReconstructExact(toks); r != e {
+			t.Errorf("%q ReconstructExact: %q", e, r)
+		}
+	}
+
+	cfg := Config{KeepTrivia: true, CurrencySymbols: []string{"$"}, MiddleDotMultiply: true}
+	e := " $1 ·  2 "
+	toks, err := ParseWithConfig(e, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := ReconstructExact(toks); r != e {
+		t.Errorf("%q ReconstructExact: %q", e, r)
+	}
+
+	toks, _ = Parse(" 1 + 2")
+	if toks[0].Raw != "" || toks[0].LeadingTrivia != "" {
+		t.Error("trivia should not be recorded without KeepTrivia: ", *toks[0])
+	}
+}
+
+func TestLineComments(t *testing.T) {
+	cfg := Config{LineComments: true}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"1 + 2 // note", 3},
+		{"1+2//", 3},
+		{"6 / 2 // 1 / 0", 3},
+		{"2 * (3 + 1) // sum, then double", 8},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig:", r)
+		}
+	}
+
+	toks, err := ParseWithConfig("1 + 2 // note", cfg)
+	if err != nil || len(toks) != 3 {
+		t.Fatal(err, " ParseWithConfig:", toks)
+	}
+	for i, offset := range []int{0, 2, 4} {
+		if toks[i].Offset != offset {
+			t.Error(toks[i], " offset want ", offset)
+		}
+	}
+
+	if _, err := ParseAndExecWithConfig("// note", cfg); err == nil {
+		t.Error("a comment only expression should be an error")
+	}
+	if _, err := ParseAndExec("1 + 2 // note"); err == nil {
+		t.Error("'//' should be an error without LineComments")
+	}
+}
+
+func TestLocaleSeparators(t *testing.T) {
+	cfg := Config{DecimalSeparator: ',', ArgumentSeparator: ';'}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"1,5 + 2,5", 4.0},
+		{"max(1,5; 2,5)", 2.5},
+		{"min(3;1,25;2) * 2", 2.5},
+		{"1e2 + 0,5", 100.5},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig:", r)
+		}
+	}
+	for _, e := range []string{"1.5 + 1", "max(1, 2)"} {
+		if _, err := ParseAndExecWithConfig(e, cfg); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+	if _, err := ParseAndExecWithConfig("1,5", Config{DecimalSeparator: ','}); err == nil {
+		t.Error("the same decimal and argument separator should be an error")
+	}
+	if r, err := ParseAndExecWithConfig("max(1.5; 2)", Config{ArgumentSeparator: ';'}); err != nil || r != 2 {
+		t.Error(err, " ParseAndExecWithConfig ArgumentSeparator:", r)
+	}
+}
+
+func TestNumberFormat(t *testing.T) {
+	exprs := []struct {
+		Expr   string
+		Format NumberFormat
+		R      float64
+	}{
+		{"1_000_000 + 0.5", PlainNumbers, 1000000.5},
+		{"1,000,000.5", CommaGrouping, 1000000.5},
+		{"1,234 * 2", CommaGrouping, 2468},
+		{"1_000,000", CommaGrouping, 1000000},
+		{"max(1,000, 2)", CommaGrouping, 1000},
+		{"max(1,2)", CommaGrouping, 2},
+		{"max(1,2345)", CommaGrouping, 2345},
+		{"max(1.5,000)", CommaGrouping, 1.5},
+		{"1,000e2", CommaGrouping, 100000},
+		{"1.000.000,5", DotGrouping, 1000000.5},
+		{"1.234 + 0,5", DotGrouping, 1234.5},
+		{"max(1.000,5; 2)", DotGrouping, 1000.5},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, Config{NumberFormat: e.Format})
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig NumberFormat:", r)
+		}
+	}
+	errs := []struct {
+		Expr   string
+		Format NumberFormat
+	}{
+		{"1,000,000.5", PlainNumbers},
+		{"1,0000", CommaGrouping},
+		{"1.5,000", CommaGrouping},
+		{"1.5", DotGrouping},
+		{"max(1, 2)", DotGrouping},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExecWithConfig(e.Expr, Config{NumberFormat: e.Format}); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+	if _, err := ParseAndExecWithConfig("1", Config{NumberFormat: DotGrouping, DecimalSeparator: '.'}); err == nil {
+		t.Error("the same grouping and decimal separator should be an error")
+	}
+	cfg := Config{NumberFormat: DotGrouping, ArgumentSeparator: '#'}
+	if r, err := ParseAndExecWithConfig("max(1.000,5# 2)", cfg); err != nil || r != 1000.5 {
+		t.Error(err, " ParseAndExecWithConfig NumberFormat ArgumentSeparator:", r)
+	}
+}
+
+func TestComparisonTokens(t *testing.T) {
+	toks, err := Parse("1==2!=3>=4<=5>>6<7")
+	if err != nil {
+		t.Fatal(err, " Parse")
+	}
+	want := []string{"1", "==", "2", "!=", "3", ">=", "4", "<=", "5", ">>", "6", "<", "7"}
+	if len(toks) != len(want) {
+		t.Fatal(len(toks), " Parse tokens, want ", len(want))
+	}
+	for i, tok := range toks {
+		if tok.Tok != want[i] {
+			t.Error(*tok, " Parse want ", want[i])
+		}
+	}
+}
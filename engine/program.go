@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AssignExprAST is the statement "Name = Value" of a program,
+// its value is the one assigned
+type AssignExprAST struct {
+	Name string
+	// of the name in the source
+	Offset int
+	Value  ExprAST
+}
+
+func (a AssignExprAST) toStr() string {
+	return fmt.Sprintf(
+		"AssignExprAST: (%s = %s)",
+		a.Name,
+		a.Value.toStr(),
+	)
+}
+
+// ExecProgram is a Top level function
+// runs the statements of s in order, separated by ';' or newlines,
+// e.g. "a = 3; b = a * 4; a + b" is 15.
+// a statement "name = expression" assigns a variable in the scope,
+// r is the value of the last statement and vars the scope after it:
+// a copy of scope with the assigned variables, scope itself is not modified.
+// a newline inside parentheses doesn't end the statement
+func ExecProgram(s string, scope map[string]float64) (r float64, vars map[string]float64, err error) {
+	return ExecProgramWithConfig(s, scope, Config{})
+}
+
+// ExecProgramWithConfig is like ExecProgram, with the optional behaviour set in cfg
+func ExecProgramWithConfig(s string, scope map[string]float64, cfg Config) (r float64, vars map[string]float64, err error) {
+	stmts, err := parseProgram(s, cfg)
+	if err != nil {
+		return 0, nil, err
+	}
+	vars = make(map[string]float64, len(scope))
+	for k, v := range scope {
+		vars[k] = v
+	}
+	for _, stmt := range stmts {
+		e := &Expression{Root: stmt, source: s, cfg: cfg}
+		if r, err = e.Eval(vars); err != nil {
+			return 0, nil, err
+		}
+	}
+	return r, vars, nil
+}
+
+// parseProgram parses the statements of s, the offsets in their ASTs are in s
+func parseProgram(s string, cfg Config) ([]ExprAST, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, errors.New("empty program")
+	}
+	toks, err := lex(s, cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	stmts := make([]ExprAST, 0)
+	var group []*Token
+	depth := 0
+	end := func() error {
+		if len(group) == 0 {
+			return nil
+		}
+		stmt, err := parseStatement(group, s, cfg)
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, stmt)
+		group = nil
+		return nil
+	}
+	for _, tok := range toks {
+		switch {
+		case tok.Type == SEMICOLON && (depth == 0 || tok.Tok == ";"):
+			if err := end(); err != nil {
+				return nil, err
+			}
+			continue
+		case tok.Type == SEMICOLON:
+			// a newline inside parentheses
+			continue
+		case tok.Tok == "(":
+			depth++
+		case tok.Tok == ")" && depth > 0:
+			depth--
+		}
+		group = append(group, tok)
+	}
+	if err := end(); err != nil {
+		return nil, err
+	}
+	if len(stmts) == 0 {
+		return nil, errors.New("empty program")
+	}
+	return stmts, nil
+}
+
+// parseStatement parses the tokens of one statement, an assignment or an expression
+func parseStatement(toks []*Token, s string, cfg Config) (ExprAST, error) {
+	if len(toks) >= 2 && toks[0].Type == Identifier && toks[1].Tok == "=" {
+		name := toks[0]
+		if _, ok := defConst[name.Tok]; ok {
+			return nil, newParseError(ErrSyntax, s, name.Offset, name.Tok,
+				fmt.Sprintf("grammar error: can't assign to the constant '%s'", name.Tok))
+		}
+		if len(toks) == 2 {
+			return nil, newParseError(ErrSyntax, s, toks[1].Offset, "=",
+				fmt.Sprintf("grammar error: no expression assigned to '%s'", name.Tok))
+		}
+		value, err := parseTokens(toks[2:], s, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return AssignExprAST{Name: name.Tok, Offset: name.Offset, Value: value}, nil
+	}
+	return parseTokens(toks, s, cfg)
+}
+
+func parseTokens(toks []*Token, s string, cfg Config) (ExprAST, error) {
+	ast := NewASTWithConfig(toks, s, cfg)
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	expr := ast.ParseExpression()
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	return expr, nil
+}
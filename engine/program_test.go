@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExecProgram(t *testing.T) {
+	type U struct {
+		Program string
+		R       float64
+	}
+	exprs := []U{
+		{"a = 3; b = a * 4; a + b", 15},
+		{"a = 3\nb = a * 4\na + b", 15},
+		{"x = 2;;\n\nx ** 3;", 8},
+		{"a = 1; a = a + 1; a", 2},
+		{"s = (1 +\n 2) * 3\ns", 9},
+		{"r = 2 // radius\npi * r ** 2", 3.141592653589793 * 4},
+		{"y = x + 1", 11},
+		{"42", 42},
+	}
+	for _, e := range exprs {
+		r, _, err := ExecProgramWithConfig(e.Program, map[string]float64{"x": 10}, Config{LineComments: true})
+		if err != nil {
+			t.Error(err, e)
+		} else if r != e.R {
+			t.Error(e, " ExecProgram:", r)
+		}
+	}
+
+	scope := map[string]float64{"x": 1}
+	_, vars, err := ExecProgram("y = x + 1; x = 5", scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["x"] != 5 || vars["y"] != 2 || scope["x"] != 1 || len(scope) != 1 {
+		t.Error(vars, scope, " ExecProgram scope")
+	}
+
+	errs := []string{
+		"",
+		" ;\n ",
+		"a = ; a",
+		"pi = 3",
+		"a = 1; b = 2 +",
+		"a = 1\nb",
+		"a = 1 / 0; 2",
+	}
+	for _, e := range errs {
+		if _, _, err := ExecProgram(e, nil); err == nil {
+			t.Error(e, " ExecProgram: want an error")
+		}
+	}
+
+	_, _, err = ExecProgram("a = 1\nb = (a +\n 2) + c", nil)
+	var ee *EvalError
+	if !errors.As(err, &ee) || ee.Token != "c" || ee.Line != 3 || ee.Column != 7 {
+		t.Error(err, " ExecProgram EvalError:", ee)
+	}
+	_, _, err = ExecProgram("a = 1\nb = a $", nil)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Line != 2 || pe.Column != 7 {
+		t.Error(err, " ExecProgram ParseError:", pe)
+	}
+}
@@ -0,0 +1,180 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ParseAndExecRat is a Top level function
+// Analytical expression and execution in exact rational arithmetic,
+// nothing is rounded, e.g. "1/3 + 1/6" is 1/2 and "0.1 + 0.2 == 0.3" is 1.
+// the result converts with RatString to a fraction, "1/2",
+// and with FloatString(prec) to a decimal with prec digits, "0.50" at 2.
+// ** takes integer exponents, % is the remainder of the division truncated toward zero,
+// comparisons and logical operators return 1 or 0;
+// bitwise and shift operators, functions and variables are errors.
+// err is not nil if an error occurs (including arithmetic runtime errors)
+func ParseAndExecRat(s string) (*big.Rat, error) {
+	toks, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	ast := NewAST(toks, s)
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	ast.exactLiterals = true
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	return ratEval(ar)
+}
+
+// ratEval evaluates an AST on big.Rat
+func ratEval(expr ExprAST) (*big.Rat, error) {
+	switch expr.(type) {
+	case BinaryExprAST:
+		ast := expr.(BinaryExprAST)
+		l, err := ratEval(ast.Lhs)
+		if err != nil {
+			return nil, err
+		}
+		if ast.Op == "?:" {
+			if l.Sign() != 0 {
+				return l, nil
+			}
+			return ratEval(ast.Rhs)
+		}
+		if ast.Op == "&&" && l.Sign() == 0 || ast.Op == "||" && l.Sign() != 0 {
+			return ratBool(l.Sign() != 0), nil
+		}
+		r, err := ratEval(ast.Rhs)
+		if err != nil {
+			return nil, err
+		}
+		switch ast.Op {
+		case "+":
+			return l.Add(l, r), nil
+		case "-":
+			return l.Sub(l, r), nil
+		case "*":
+			return l.Mul(l, r), nil
+		case "/", "%":
+			if r.Sign() == 0 {
+				return nil, evalError(ErrDivisionByZero, ast.Op,
+					fmt.Sprintf("violation of arithmetic specification: a division by zero in ParseAndExecRat: [%s%s%s]",
+						l.RatString(),
+						ast.Op,
+						r.RatString()))
+			}
+			q := new(big.Rat).Quo(l, r)
+			if ast.Op == "/" {
+				return q, nil
+			}
+			// l - r * trunc(l / r)
+			t := new(big.Int).Quo(q.Num(), q.Denom())
+			return l.Sub(l, r.Mul(r, new(big.Rat).SetInt(t))), nil
+		case "**":
+			return ratPow(l, r)
+		case ">", "<", ">=", "<=", "==", "!=":
+			c := l.Cmp(r)
+			return ratBool(ast.Op == ">" && c > 0 || ast.Op == "<" && c < 0 ||
+				ast.Op == ">=" && c >= 0 || ast.Op == "<=" && c <= 0 ||
+				ast.Op == "==" && c == 0 || ast.Op == "!=" && c != 0), nil
+		case "&&", "||":
+			return ratBool(r.Sign() != 0), nil
+		default:
+			return nil, errors.New(
+				fmt.Sprintf("operator '%s' is not supported in ParseAndExecRat", ast.Op))
+		}
+	case UnaryExprAST:
+		u := expr.(UnaryExprAST)
+		v, err := ratEval(u.Operand)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case u.Postfix:
+			if !v.IsInt() {
+				return nil, evalError(ErrDomain, u.Op,
+					fmt.Sprintf("domain error: the factorial of %s is undefined in ParseAndExecRat", v.RatString()))
+			}
+			f, err := bigFactorial(v.Num(), "ParseAndExecRat")
+			if err != nil {
+				return nil, err
+			}
+			return new(big.Rat).SetInt(f), nil
+		case u.Op == "-":
+			return v.Neg(v), nil
+		case u.Op == "+":
+			return v, nil
+		case u.Op == "!":
+			return ratBool(v.Sign() == 0), nil
+		}
+		return nil, errors.New(
+			fmt.Sprintf("operator '%s' is not supported in ParseAndExecRat", u.Op))
+	case ConditionalExprAST:
+		c := expr.(ConditionalExprAST)
+		cond, err := ratEval(c.Cond)
+		if err != nil {
+			return nil, err
+		}
+		if cond.Sign() != 0 {
+			return ratEval(c.Then)
+		}
+		return ratEval(c.Else)
+	case NumberExprAST:
+		n := expr.(NumberExprAST)
+		if n.Str == "" {
+			// built without source text
+			n.Str = Float64ToStr(n.Val)
+		}
+		// exact value of the literal, not its float64 rounding
+		v, ok := new(big.Rat).SetString(n.Str)
+		if !ok {
+			return nil, errors.New(
+				fmt.Sprintf("literal %s is not a rational number in ParseAndExecRat", n.Str))
+		}
+		return v, nil
+	case VariableExprAST:
+		return nil, errors.New(
+			fmt.Sprintf("variable '%s' is not supported in ParseAndExecRat", expr.(VariableExprAST).Name))
+	case FunCallerExprAST:
+		return nil, errors.New(
+			fmt.Sprintf("function '%s' is not supported in ParseAndExecRat", expr.(FunCallerExprAST).Name))
+	}
+	return new(big.Rat), nil
+}
+
+// ratPow is l ** r for an integer r, bounded by bigMaxBits like ParseAndExecBig
+func ratPow(l, r *big.Rat) (*big.Rat, error) {
+	if !r.IsInt() {
+		return nil, evalError(ErrInvalidOperand, "**",
+			fmt.Sprintf("the exponent of [%s**%s] is not an integer in ParseAndExecRat", l.RatString(), r.RatString()))
+	}
+	e := new(big.Int).Abs(r.Num())
+	if e.Sign() > 0 && r.Sign() < 0 && l.Sign() == 0 {
+		return nil, evalError(ErrDivisionByZero, "**",
+			fmt.Sprintf("violation of arithmetic specification: a division by zero in ParseAndExecRat: [0**%s]", r.RatString()))
+	}
+	bits := l.Num().BitLen() + l.Denom().BitLen() - 2
+	if bits > 0 && (!e.IsInt64() || int64(bits)*e.Int64() > bigMaxBits) {
+		return nil, evalError(ErrOverflow, "**",
+			fmt.Sprintf("the result of [%s**%s] is too large in ParseAndExecRat", l.RatString(), r.RatString()))
+	}
+	num := new(big.Int).Exp(l.Num(), e, nil)
+	den := new(big.Int).Exp(l.Denom(), e, nil)
+	if r.Sign() < 0 {
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+func ratBool(b bool) *big.Rat {
+	if b {
+		return big.NewRat(1, 1)
+	}
+	return new(big.Rat)
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseAndExecRat(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    string
+	}{
+		{"1/3 + 1/6", "1/2"},
+		{"0.1 + 0.2 == 0.3", "1"},
+		{"0.1 + 0.2", "3/10"},
+		{"(2/3) ** -2", "9/4"},
+		{"(-1/2) ** 3", "-1/8"},
+		{"0 ** 0", "1"},
+		{"7/2 % 1", "1/2"},
+		{"-7 % 2", "-1"},
+		{"1e-3 * 0x10", "2/125"},
+		{"4! / 3 - +1", "7"},
+		{"1/3 > 0.333 && !0", "1"},
+		{"0 ?: 2/4", "1/2"},
+		{"1 ? 1/3 : 1/0", "1/3"},
+		{"12345678901234567890 * 10 / 3", "41152263004115226300"},
+		{"1e400 / 1e399 + 1e-400 * 1e400", "11"},
+		{"1" + strings.Repeat("0", 400) + " / 10 ** 399", "10"},
+		{"0x" + strings.Repeat("f", 40) + " / 2", "1461501637330902918203684832716283019655932542975/2"},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecRat(e.Expr)
+		if err != nil || r.RatString() != e.R {
+			t.Error(err, e, " ParseAndExecRat:", r)
+		}
+	}
+	if r, err := ParseAndExecRat("2/3"); err != nil || r.FloatString(5) != "0.66667" {
+		t.Error(err, " ParseAndExecRat FloatString:", r)
+	}
+
+	errs := []struct {
+		Expr string
+		Kind ErrorKind
+	}{
+		{"1 / (1/3 - 1/3)", ErrDivisionByZero},
+		{"1 % 0", ErrDivisionByZero},
+		{"0 ** -1", ErrDivisionByZero},
+		{"2 ** 0.5", ErrInvalidOperand},
+		{"3 ** 99999999999", ErrOverflow},
+		{"(1/2)!", ErrDomain},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExecRat(e.Expr); !errors.Is(err, e.Kind) {
+			t.Error(err, e, " ParseAndExecRat")
+		}
+	}
+	for _, e := range []string{"1 << 2", "~1", "x + 1", "abs(1)"} {
+		if _, err := ParseAndExecRat(e); err == nil || !strings.Contains(err.Error(), "not supported in ParseAndExecRat") {
+			t.Error(err, e, " ParseAndExecRat")
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"math"
+)
+
+// Simplify is a Top level function
+// returns an equivalent AST with the constant subtrees folded ("2*3+x" is "6+x"),
+// the identity operations removed (x+0, 0+x, x-0, x*1, 1*x, x/1),
+// x*0 and 0*x replaced by 0, and the double negations normalized
+// ("--x" and "+x" are "x", "x - -y" is "x + y", "x + -3" is "x - 3").
+// a constant subtree whose evaluation fails, such as 1/0, is kept to fail at evaluation.
+// calls of built-in functions with constant arguments are folded, the trigonometric
+// ones with the current TrigonometricMode, registered functions are never called.
+// x*0 is 0 even if the evaluation of x would fail, e.g. with an undefined variable
+func Simplify(expr ExprAST) ExprAST {
+	switch e := expr.(type) {
+	case BinaryExprAST:
+		if percentOf(e) {
+			// the percentage of the left side is only folded along with it
+			p := e.Rhs.(UnaryExprAST)
+			b := BinaryExprAST{Op: e.Op, Lhs: Simplify(e.Lhs),
+				Rhs: UnaryExprAST{Op: p.Op, Operand: Simplify(p.Operand), Postfix: true}}
+			_, lok := b.Lhs.(NumberExprAST)
+			if _, ok := b.Rhs.(UnaryExprAST).Operand.(NumberExprAST); ok && lok {
+				return foldConst(b)
+			}
+			return b
+		}
+		rhs := Simplify(e.Rhs)
+		if (e.Op == "+" || e.Op == "-") && isPercent(rhs) {
+			// "x + +y%" is x + y / 100, "x + y%" would be a percentage of x
+			rhs = simplifyBinary(BinaryExprAST{Op: "/", Lhs: rhs.(UnaryExprAST).Operand, Rhs: number(100)})
+		}
+		return simplifyBinary(BinaryExprAST{Op: e.Op, Lhs: Simplify(e.Lhs), Rhs: rhs})
+	case UnaryExprAST:
+		u := UnaryExprAST{Op: e.Op, Operand: Simplify(e.Operand), Postfix: e.Postfix}
+		if _, ok := u.Operand.(NumberExprAST); ok {
+			return foldConst(u)
+		}
+		if neg, ok := negated(u.Operand); ok && u.Op == "-" && !u.Postfix {
+			// --x
+			return neg
+		}
+		if u.Op == "+" && !u.Postfix {
+			return u.Operand
+		}
+		return u
+	case ConditionalExprAST:
+		c := ConditionalExprAST{Cond: Simplify(e.Cond), Then: Simplify(e.Then), Else: Simplify(e.Else)}
+		if n, ok := c.Cond.(NumberExprAST); ok {
+			if n.Val != 0 {
+				return c.Then
+			}
+			return c.Else
+		}
+		return c
+	case FunCallerExprAST:
+		f := FunCallerExprAST{Name: e.Name, Arg: make([]ExprAST, len(e.Arg))}
+		constant := true
+		for i, arg := range e.Arg {
+			f.Arg[i] = Simplify(arg)
+			_, ok := f.Arg[i].(NumberExprAST)
+			constant = constant && ok
+		}
+		if _, user, ok := lookupFunc(f.Name); ok && user == nil && constant {
+			return foldConst(f)
+		}
+		return f
+	}
+	return expr
+}
+
+// simplifyBinary simplifies b, its operands are already simplified
+func simplifyBinary(b BinaryExprAST) ExprAST {
+	l, lok := b.Lhs.(NumberExprAST)
+	r, rok := b.Rhs.(NumberExprAST)
+	if lok && rok {
+		return foldConst(b)
+	}
+	if lok {
+		// a constant left side decides ?: && || on its own
+		if v, ok := (&evaluator{}).shortCircuit(b.Op, l.Val); ok {
+			return number(v)
+		}
+		if b.Op == "?:" {
+			return b.Rhs
+		}
+	}
+	switch {
+	case b.Op == "+" && rok && r.Val == 0, b.Op == "-" && rok && r.Val == 0,
+		b.Op == "*" && rok && r.Val == 1, b.Op == "/" && rok && r.Val == 1:
+		return b.Lhs
+	case b.Op == "+" && lok && l.Val == 0, b.Op == "*" && lok && l.Val == 1:
+		return b.Rhs
+	case b.Op == "*" && (rok && r.Val == 0 || lok && l.Val == 0):
+		return number(0)
+	}
+	if rok && r.Val < 0 && (b.Op == "-" || b.Op == "+") {
+		// x - -3 is x + 3
+		op := "+"
+		if b.Op == "+" {
+			op = "-"
+		}
+		return BinaryExprAST{Op: op, Lhs: b.Lhs, Rhs: number(-r.Val)}
+	}
+	// "x - -(10%)" is not "x + 10%", a percentage of x
+	if neg, ok := negated(b.Rhs); ok && (b.Op == "-" || b.Op == "+") && !isPercent(neg) {
+		if b.Op == "-" {
+			return BinaryExprAST{Op: "+", Lhs: b.Lhs, Rhs: neg}
+		}
+		return BinaryExprAST{Op: "-", Lhs: b.Lhs, Rhs: neg}
+	}
+	return b
+}
+
+// negated returns x if expr is the unary minus -x
+func negated(expr ExprAST) (ExprAST, bool) {
+	if u, ok := expr.(UnaryExprAST); ok && u.Op == "-" && !u.Postfix {
+		return u.Operand, true
+	}
+	return nil, false
+}
+
+// foldConst evaluates the constant expr, it is kept if that fails
+func foldConst(expr ExprAST) ExprAST {
+	v, err := EvalAST(expr)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return expr
+	}
+	return number(v)
+}
+
+func number(v float64) NumberExprAST {
+	return NumberExprAST{Val: v, Str: Float64ToStr(v)}
+}
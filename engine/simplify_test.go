@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		Want string
+	}{
+		{"2*3+x", "(6 + x)"},
+		{"x + 0", "x"},
+		{"0 + x", "x"},
+		{"x - 0", "x"},
+		{"1 * x * 1", "x"},
+		{"x / 1", "x"},
+		{"x * 0 + y", "y"},
+		{"(2 - 2) * max(x, y)", "0"},
+		{"--x", "x"},
+		{"---x", "(-x)"},
+		{"+x * 3!", "(x * 6)"},
+		{"-2 ** 2 + x", "(-4 + x)"},
+		{"x - -y", "(x + y)"},
+		{"x + -y", "(x - y)"},
+		{"x + -3", "(x - 3)"},
+		{"x - (1 - 4)", "(x + 3)"},
+		{"sqrt(16) * x", "(4 * x)"},
+		{"max(1, 2, x)", "max(1, 2, x)"},
+		{"1 > 2 ? x : y + 2 * 2", "(y + 4)"},
+		{"c ? 1 + 1 : 2", "(c ? 2 : 2)"},
+		{"0 ?: x", "x"},
+		{"3 ?: x", "3"},
+		{"0 && x", "0"},
+		{"2 || x", "1"},
+		{"x / (1 - 1)", "(x / 0)"},
+		{"1 / 0 + x", "((1 / 0) + x)"},
+		{"-(2 ** 3)", "-8"},
+	}
+	for _, e := range exprs {
+		s := ExprASTToString(Simplify(parseExpr(t, e.Expr)))
+		if s != e.Want {
+			t.Error(e, " Simplify:", s)
+		}
+	}
+
+	// the simplified AST evaluates to the same result
+	vars := map[string]float64{"x": 3.5, "y": -2, "c": 1}
+	for _, e := range exprs {
+		if e.Expr == "x * 0 + y" || e.Expr == "x / (1 - 1)" || e.Expr == "1 / 0 + x" {
+			continue
+		}
+		ar := parseExpr(t, e.Expr)
+		want := ExprASTResultWith(ar, vars)
+		if r := ExprASTResultWith(Simplify(ar), vars); r != want {
+			t.Error(e, " Simplify result:", r, " want:", want)
+		}
+	}
+	if _, err := EvalAST(Simplify(parseExpr(t, "1 / 0 + 2"))); err == nil {
+		t.Error("a failing constant subtree should fail after Simplify")
+	}
+}
@@ -1,9 +1,12 @@
 package engine
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
+	"math/big"
+	"reflect"
 	"strconv"
 	"strings"
 )
@@ -11,37 +14,124 @@ import (
 // Top level function
 // Analytical expression and execution
 // err is not nil if an error occurs (including arithmetic runtime errors)
-func ParseAndExec(s string) (r int, err error) {
-	toks, err := Parse(s)
+func ParseAndExec(s string) (r float64, err error) {
+	return ParseAndExecWithConfig(s, Config{})
+}
+
+// ParseAndExecWithConfig is a Top level function
+// like ParseAndExec, with the optional behaviour set in cfg
+func ParseAndExecWithConfig(s string, cfg Config) (r float64, err error) {
+	e, err := CompileWithConfig(s, cfg)
 	if err != nil {
 		return 0, err
 	}
-	ast := NewAST(toks, s)
-	if ast.Err != nil {
-		return 0, ast.Err
+	return e.Eval(nil)
+}
+
+// ParseAndExecWith is a Top level function
+// like ParseAndExec, the variables of s are looked up in vars
+func ParseAndExecWith(s string, vars map[string]float64) (r float64, err error) {
+	e, err := Compile(s)
+	if err != nil {
+		return 0, err
 	}
-	ar := ast.ParseExpression()
-	if ast.Err != nil {
-		return 0, ast.Err
+	return e.Eval(vars)
+}
+
+// EvalInto is a Top level function
+// Analytical expression, execution and assign the result to target
+// target must be a pointer to an int, int8 ~ int64, float32, float64 or bool
+// err is not nil if the result does not fit in the type of target
+func EvalInto(s string, target interface{}) error {
+	r, err := ParseAndExec(s)
+	if err != nil {
+		return err
 	}
-	defer func() {
-		if e := recover(); e != nil {
-			err = e.(error)
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New(fmt.Sprintf("EvalInto: want a non-nil pointer but get %T", target))
+	}
+	v = v.Elem()
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if r != math.Trunc(r) {
+			return errors.New(fmt.Sprintf("EvalInto: result %g is not an integer", r))
 		}
-	}()
-	return ExprASTResult(ar), err
+		if r < -(1<<63) || r >= 1<<63 || v.OverflowInt(int64(r)) {
+			return errors.New(fmt.Sprintf("EvalInto: result %g overflows %s", r, v.Type()))
+		}
+		v.SetInt(int64(r))
+	case reflect.Float32, reflect.Float64:
+		if v.OverflowFloat(r) {
+			return errors.New(fmt.Sprintf("EvalInto: result %g overflows %s", r, v.Type()))
+		}
+		v.SetFloat(r)
+	case reflect.Bool:
+		if r != 0 && r != 1 {
+			return errors.New(fmt.Sprintf("EvalInto: result %g is not a bool, want 0 or 1", r))
+		}
+		v.SetBool(r == 1)
+	default:
+		return errors.New(fmt.Sprintf("EvalInto: unsupported target type %s", v.Type()))
+	}
+	return nil
 }
 
-func ErrPos(s string, pos int) string {
-	r := strings.Repeat("-", len(s)) + "\n"
+// PanicMapper converts a value recovered while executing an expression into
+// the error returned by ParseAndExec.
+// return nil to fall back to the default conversion
+var PanicMapper func(e interface{}) error
+
+// panicToError converts a recovered value into an error,
+// non-error values are wrapped instead of panicking again
+func panicToError(e interface{}) error {
+	if PanicMapper != nil {
+		if err := PanicMapper(e); err != nil {
+			return err
+		}
+	}
+	if err, ok := e.(error); ok {
+		return err
+	}
+	return fmt.Errorf("panic: %v", e)
+}
+
+// ErrFormatOptions controls how FormatError marks a position in the source.
+// the zero value produces the same output as ErrPos
+type ErrFormatOptions struct {
+	// Caret points at the position, default '^'
+	Caret rune
+	// Rule draws the lines above and below the source, default '-'
+	Rule rune
+	// OmitRule drops the rule lines
+	OmitRule bool
+}
+
+// FormatError marks pos in s according to opts
+func FormatError(s string, pos int, opts ErrFormatOptions) string {
+	caret, rule := opts.Caret, opts.Rule
+	if caret == 0 {
+		caret = '^'
+	}
+	if rule == 0 {
+		rule = '-'
+	}
+	r := ""
+	if !opts.OmitRule {
+		r = strings.Repeat(string(rule), len(s)) + "\n"
+	}
 	s += "\n"
 	for i := 0; i < pos; i++ {
 		s += " "
 	}
-	s += "^\n"
+	s += string(caret) + "\n"
 	return r + s + r
 }
 
+func ErrPos(s string, pos int) string {
+	return FormatError(s, pos, ErrFormatOptions{})
+}
+
 // the integer power of a number
 func Pow(x float64, n float64) float64 {
 	return math.Pow(x, n)
@@ -54,57 +144,690 @@ func Float64ToStr(f float64) string {
 
 // ExprASTResult is a Top level function
 // AST traversal
-// if an arithmetic runtime error occurs, a panic exception is thrown
-func ExprASTResult(expr ExprAST) int {
-	var l, r int
+// if an arithmetic runtime error occurs, a panic exception is thrown.
+//
+// Deprecated: use Eval, which returns the error instead
+func ExprASTResult(expr ExprAST) float64 {
+	r, err := Eval(expr)
+	if err != nil {
+		panic(err)
+	}
+	return r.Value
+}
+
+// ExprASTResultWith is like ExprASTResult, the variables of expr are looked up in vars.
+//
+// Deprecated: use Expression.Eval, which returns the error instead
+func ExprASTResultWith(expr ExprAST, vars map[string]float64) float64 {
+	r, err := (&evaluator{vars: vars}).run(expr)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// EvalAST is a Top level function
+// AST traversal
+// err is not nil if an arithmetic runtime error occurs
+func EvalAST(expr ExprAST) (float64, error) {
+	return (&evaluator{}).run(expr)
+}
+
+// Result is the value of an expression evaluated by Eval
+type Result struct {
+	Value float64
+}
+
+// Int returns the value as an int64,
+// ok is false if it isn't an integer or overflows int64
+func (r Result) Int() (i int64, ok bool) {
+	if r.Value != math.Trunc(r.Value) || r.Value < -(1<<63) || r.Value >= 1<<63 {
+		return 0, false
+	}
+	return int64(r.Value), true
+}
+
+func (r Result) String() string {
+	return Float64ToStr(r.Value)
+}
+
+// Eval is a Top level function
+// AST traversal, every arithmetic runtime error (a division or modulo by zero,
+// an overflow, a bad function call) is returned as an error, Eval never panics
+func Eval(expr ExprAST) (Result, error) {
+	r, err := EvalAST(expr)
+	return Result{Value: r}, err
+}
+
+// EvalWithContext is a Top level function
+// like Eval, s is parsed and evaluated with the options and limits set in cfg,
+// e.g. Config{MaxDepth: 50, MaxNodes: 1000, MaxSteps: 10000} for untrusted input.
+// the evaluation stops with an ErrCanceled EvalError once ctx is done
+func EvalWithContext(ctx context.Context, s string, cfg Config) (Result, error) {
+	e, err := CompileWithConfig(s, cfg)
+	if err != nil {
+		return Result{}, err
+	}
+	r, err := e.EvalWithContext(ctx, nil)
+	return Result{Value: r}, err
+}
+
+// Metrics counts the operators and functions evaluated by EvalWithMetrics
+type Metrics struct {
+	// Ops maps an operator to the number of times it was evaluated
+	Ops map[string]int
+	// Funcs maps a function name to the number of times it was called
+	Funcs map[string]int
+}
+
+// EvalWithMetrics is a Top level function
+// AST traversal, counting every operator and function evaluated
+// err is not nil if an arithmetic runtime error occurs
+func EvalWithMetrics(expr ExprAST) (r float64, m Metrics, err error) {
+	m = Metrics{Ops: map[string]int{}, Funcs: map[string]int{}}
+	r, err = (&evaluator{metrics: &m}).run(expr)
+	return r, m, err
+}
+
+// AuditRecord is the provenance of a result of EvalWithAudit
+type AuditRecord struct {
+	// Reads are the variables read, in evaluation order
+	Reads []VariableRead
+	// Calls are the functions called, in evaluation order
+	Calls  []FunctionCall
+	Result float64
+}
+
+// VariableRead is a variable read during evaluation
+type VariableRead struct {
+	Name  string
+	Value float64
+}
+
+// FunctionCall is a function called during evaluation with its evaluated arguments,
+// it is recorded once the arguments are evaluated
+type FunctionCall struct {
+	Name string
+	Args []float64
+}
+
+// EvalWithAudit is a Top level function
+// AST traversal with the variables of expr looked up in vars,
+// recording every variable read and function called.
+// err is not nil if an arithmetic runtime error occurs,
+// the record then holds what was evaluated before it
+func EvalWithAudit(expr ExprAST, vars map[string]float64) (r float64, rec AuditRecord, err error) {
+	rec.Result, err = (&evaluator{vars: vars, audit: &rec}).run(expr)
+	return rec.Result, rec, err
+}
+
+// EvalSubtree is a Top level function
+// AST traversal of the sub-expression of expr found by path,
+// each index selects one of the Children, e.g. []int{1, 0} is the Lhs of the Rhs.
+// err is not nil if path is invalid or an arithmetic runtime error occurs
+func EvalSubtree(expr ExprAST, path []int) (r float64, err error) {
+	for i, idx := range path {
+		c := Children(expr)
+		if idx < 0 || idx >= len(c) {
+			return 0, errors.New(
+				fmt.Sprintf("invalid path %v: index %d at step %d, the node has %d children",
+					path,
+					idx,
+					i,
+					len(c)))
+		}
+		expr = c[idx]
+	}
+	return EvalAST(expr)
+}
+
+// ParseTreeLevels is a Top level function
+// Analytical expression and returns the operators grouped by their depth in the AST,
+// levels[0] holds the root operator, deeper levels bind tighter,
+// e.g. "1 + 2 * 3 - 4" is [[-] [+] [*]]
+func ParseTreeLevels(s string) (levels [][]string, err error) {
+	toks, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	ast := NewAST(toks, s)
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		return nil, ast.Err
+	}
+	var walk func(expr ExprAST, depth int)
+	walk = func(expr ExprAST, depth int) {
+		if b, ok := expr.(BinaryExprAST); ok {
+			if depth == len(levels) {
+				levels = append(levels, nil)
+			}
+			levels[depth] = append(levels[depth], b.Op)
+			depth++
+		}
+		for _, c := range Children(expr) {
+			walk(c, depth)
+		}
+	}
+	walk(ar, 0)
+	return levels, nil
+}
+
+// WillPanic is a Top level function
+// reports whether evaluating expr is guaranteed to fail at runtime,
+// with a constant zero divisor of '/' or '%', or a constant negative shift count.
+// reason describes the first fault found
+func WillPanic(expr ExprAST) (panics bool, reason string) {
+	if ast, ok := expr.(BinaryExprAST); ok && (ast.Op == "?:" || ast.Op == "||" || ast.Op == "&&") {
+		// the right side only runs when the left is always 0, or nonzero for &&
+		if panics, reason = WillPanic(ast.Lhs); panics {
+			return
+		}
+		if l, ok := constValue(ast.Lhs); ok && (l == 0) != (ast.Op == "&&") {
+			return WillPanic(ast.Rhs)
+		}
+		return false, ""
+	}
+	if c, ok := expr.(ConditionalExprAST); ok {
+		// only the branch taken runs
+		if panics, reason = WillPanic(c.Cond); panics {
+			return
+		}
+		if cond, ok := constValue(c.Cond); ok {
+			if cond != 0 {
+				return WillPanic(c.Then)
+			}
+			return WillPanic(c.Else)
+		}
+		return false, ""
+	}
+	if f, ok := expr.(FunCallerExprAST); ok && f.Name == "noerr" {
+		// the panics of the argument are recovered
+		return false, ""
+	}
+	for _, c := range Children(expr) {
+		if panics, reason = WillPanic(c); panics {
+			return
+		}
+	}
+	ast, ok := expr.(BinaryExprAST)
+	if !ok {
+		return false, ""
+	}
+	r, ok := constValue(ast.Rhs)
+	if !ok {
+		return false, ""
+	}
+	switch {
+	case ast.Op == "/" && r == 0:
+		return true, "a division by zero, the divisor of '/' is always 0"
+	case ast.Op == "%" && r == 0:
+		return true, "a modulo by zero, the divisor of '%' is always 0"
+	case (ast.Op == "<<" || ast.Op == ">>") && r < 0:
+		return true, fmt.Sprintf("a negative shift count, the count of '%s' is always %g", ast.Op, r)
+	}
+	return false, ""
+}
+
+// constValue folds expr if it only contains numbers and operators
+func constValue(expr ExprAST) (r float64, ok bool) {
+	var isConst func(expr ExprAST) bool
+	isConst = func(expr ExprAST) bool {
+		switch e := expr.(type) {
+		case NumberExprAST:
+			return true
+		case BinaryExprAST:
+			return isConst(e.Lhs) && isConst(e.Rhs)
+		case UnaryExprAST:
+			return isConst(e.Operand)
+		}
+		return false
+	}
+	if !isConst(expr) {
+		return 0, false
+	}
+	r, err := EvalAST(expr)
+	return r, err == nil
+}
+
+// evaluator holds the state of a single AST traversal
+type evaluator struct {
+	cfg     Config
+	vars    map[string]float64
+	metrics *Metrics
+	audit   *AuditRecord
+
+	// ctx, if set, stops the evaluation when it is done
+	ctx   context.Context
+	steps int
+}
+
+// contextCheckSteps is the number of steps between two checks of the context
+const contextCheckSteps = 1024
+
+// step counts a node evaluated against Config.MaxSteps,
+// and checks the context every contextCheckSteps steps
+func (ev *evaluator) step() error {
+	ev.steps++
+	if max := ev.cfg.MaxSteps; max > 0 && ev.steps > max {
+		return evalError(ErrLimit, "",
+			fmt.Sprintf("evaluation limit exceeded: more than %d steps", max))
+	}
+	if ev.ctx != nil && ev.steps%contextCheckSteps == 1 {
+		if err := ev.ctx.Err(); err != nil {
+			e := evalError(ErrCanceled, "", "evaluation canceled: "+err.Error())
+			e.cause = err
+			return e
+		}
+	}
+	return nil
+}
+
+func (ev *evaluator) trueValue() float64 {
+	if ev.cfg.TrueValue == 0 {
+		return 1
+	}
+	return ev.cfg.TrueValue
+}
+
+// boolValue maps the result of a comparison to Config.TrueValue or FalseValue
+func (ev *evaluator) boolValue(b bool) float64 {
+	if b {
+		return ev.trueValue()
+	}
+	return ev.cfg.FalseValue
+}
+
+// run evaluates expr, a panic of a registered function is returned as an error
+func (ev *evaluator) run(expr ExprAST) (r float64, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			r, err = 0, panicToError(e)
+		}
+	}()
+	return ev.eval(expr)
+}
+
+func (ev *evaluator) eval(expr ExprAST) (float64, error) {
+	if err := ev.step(); err != nil {
+		return 0, err
+	}
+	v, err := ev.evalNode(expr)
+	if err != nil {
+		return 0, err
+	}
+	return v, ev.limit(v)
+}
+
+// limit checks v against Config.MaxAbsResult
+func (ev *evaluator) limit(v float64) error {
+	if max := ev.cfg.MaxAbsResult; max > 0 && (v > max || v < -max) {
+		// 'g', a large v or max is not written with all its digits
+		return evalError(ErrOverflow, "",
+			fmt.Sprintf("result magnitude exceeds limit: %s is outside [-%s, %s]",
+				strconv.FormatFloat(v, 'g', -1, 64),
+				strconv.FormatFloat(max, 'g', -1, 64),
+				strconv.FormatFloat(max, 'g', -1, 64)))
+	}
+	return nil
+}
+
+func (ev *evaluator) evalNode(expr ExprAST) (float64, error) {
 	switch expr.(type) {
 	case BinaryExprAST:
 		ast := expr.(BinaryExprAST)
-		l = ExprASTResult(ast.Lhs)
-		r = ExprASTResult(ast.Rhs)
-		switch ast.Op {
-		case "+":
-			return l + r
-		case "-":
-			return l - r
-		case "*":
-			return l * r
-		case "/":
-			if r == 0 {
-				panic(errors.New(
-					fmt.Sprintf("violation of arithmetic specification: a division by zero in ExprASTResult: [%g/%g]",
-						l,
-						r)))
-			}
-			return l / r
-		case "%":
-			return l % r
-		case "^":
-			return l ^ r
-		case ">>":
-			return l >> r
-		case "<<":
-			return l << r
-		case ">":
-			if l > r {
-				return 1
-			} else {
-				return 0
+		if ev.metrics != nil {
+			ev.metrics.Ops[ast.Op]++
+		}
+		l, err := ev.eval(ast.Lhs)
+		if err != nil {
+			return 0, err
+		}
+		if v, ok := ev.shortCircuit(ast.Op, l); ok {
+			return v, nil
+		}
+		r, err := ev.eval(ast.Rhs)
+		if err != nil {
+			return 0, err
+		}
+		if percentOf(ast) {
+			r = l * r
+		}
+		return ev.binary(ast.Op, l, r)
+	case UnaryExprAST:
+		u := expr.(UnaryExprAST)
+		if ev.metrics != nil {
+			ev.metrics.Ops[unaryName(u)]++
+		}
+		v, err := ev.eval(u.Operand)
+		if err != nil {
+			return 0, err
+		}
+		return ev.unary(u.Op, u.Postfix, v)
+	case ConditionalExprAST:
+		c := expr.(ConditionalExprAST)
+		cond, err := ev.eval(c.Cond)
+		if err != nil {
+			return 0, err
+		}
+		if cond != 0 {
+			return ev.eval(c.Then)
+		}
+		return ev.eval(c.Else)
+	case NumberExprAST:
+		return expr.(NumberExprAST).Val, nil
+	case VariableExprAST:
+		name := expr.(VariableExprAST).Name
+		v, err := ev.variable(name, expr.(VariableExprAST).Offset)
+		if err == nil && ev.audit != nil {
+			ev.audit.Reads = append(ev.audit.Reads, VariableRead{name, v})
+		}
+		return v, err
+	case FunCallerExprAST:
+		f := expr.(FunCallerExprAST)
+		if ev.metrics != nil {
+			ev.metrics.Funcs[f.Name]++
+		}
+		def, user, ok := lookupFunc(f.Name)
+		if !ok {
+			return 0, evalError(ErrUnknownIdentifier, f.Name,
+				fmt.Sprintf("function '%s' is undefined", f.Name))
+		}
+		if err := checkArgc(f.Name, def, len(f.Arg)); err != nil {
+			return 0, err
+		}
+		args := make([]float64, len(f.Arg))
+		if def.fun == nil && user == nil {
+			// noerr, 0 if its argument is an arithmetic error,
+			// an exceeded limit or a canceled evaluation stops it too
+			v, err := ev.run(f.Arg[0])
+			if errors.Is(err, ErrLimit) || errors.Is(err, ErrCanceled) {
+				return 0, err
 			}
-		case "<":
-			if l < r {
-				return 1
-			} else {
-				return 0
+			args[0] = v
+		} else {
+			for i, arg := range f.Arg {
+				v, err := ev.eval(arg)
+				if err != nil {
+					return 0, err
+				}
+				args[i] = v
 			}
-		case "&":
-			return l & r
-		default:
+		}
+		if ev.audit != nil {
+			ev.audit.Calls = append(ev.audit.Calls, FunctionCall{f.Name, args})
+		}
+		return ev.call(f.Name, def, user, args)
+	case AssignExprAST:
+		a := expr.(AssignExprAST)
+		v, err := ev.eval(a.Value)
+		if err != nil {
+			return 0, err
+		}
+		if ev.vars == nil {
+			ev.vars = map[string]float64{}
+		}
+		ev.vars[a.Name] = v
+		return v, nil
+	}
 
+	return 0.0, nil
+}
+
+// variable looks name, at offset of the source, up in vars, then in the constants
+func (ev *evaluator) variable(name string, offset int) (float64, error) {
+	v, ok := ev.vars[name]
+	if !ok {
+		if v, ok = lookupConst(name); !ok {
+			err := undefinedVariableError(name, offset)
+			err.Msg += didYouMean(name, identNames(ev.vars))
+			return 0, err
 		}
-	case NumberExprAST:
-		return expr.(NumberExprAST).Val
 	}
+	return v, nil
+}
+
+// identNames are the names of vars and of the constants,
+// the candidates of the suggestion for an undefined variable
+func identNames(vars map[string]float64) []string {
+	names := constNames()
+	for name := range vars {
+		names = append(names, name)
+	}
+	return names
+}
+
+// call calls the function name found by lookupFunc with the evaluated args
+func (ev *evaluator) call(name string, def defS, user *userDef, args []float64) (float64, error) {
+	switch {
+	case user != nil && user.exprFn != nil:
+		exprs := make([]ExprAST, len(args))
+		for i, a := range args {
+			exprs[i] = NumberExprAST{Val: a}
+		}
+		return user.exprFn(exprs...), nil
+	case user != nil:
+		return user.fn(args)
+	case def.fun != nil:
+		r := def.fun(args...)
+		return r, checkDomain(name, args, r)
+	}
+	// noerr
+	return args[0], nil
+}
+
+// shortCircuit returns the result of the operator op when its left side l
+// decides it, the right side isn't evaluated then
+func (ev *evaluator) shortCircuit(op string, l float64) (float64, bool) {
+	switch {
+	case op == "?:" && l != 0:
+		// elvis, the right side is only evaluated when the left is 0
+		return l, true
+	case op == "&&" && l == 0, op == "||" && l != 0:
+		return ev.boolValue(l != 0), true
+	case ev.cfg.LogicalBitwise && op == "&" && l == 0:
+		return 0, true
+	case ev.cfg.LogicalBitwise && op == "|" && l == -1:
+		// all bits set, -1 | x is -1 for any integer x
+		return l, true
+	}
+	return 0, false
+}
+
+// binary applies the operator op to the evaluated operands l and r
+func (ev *evaluator) binary(op string, l, r float64) (float64, error) {
+	switch op {
+	case "+", "-":
+		return exactOp(op, l, r), nil
+	case "*":
+		return l * r, nil
+	case "**":
+		// the errors of pow, so that l ** r and pow(l, r) agree
+		v := Pow(l, r)
+		return v, checkDomain("pow", []float64{l, r}, v)
+	case "/":
+		if r == 0 {
+			return 0, evalError(ErrDivisionByZero, op,
+				fmt.Sprintf("violation of arithmetic specification: a division by zero in ExprASTResult: [%s/%s]",
+					Float64ToStr(l),
+					Float64ToStr(r)))
+		}
+		return l / r, nil
+	case "%", "^", ">>", "<<", "&", "|":
+		return intOp(op, l, r)
+	case ">":
+		return ev.boolValue(l > r), nil
+	case "<":
+		return ev.boolValue(l < r), nil
+	case ">=":
+		return ev.boolValue(l >= r), nil
+	case "<=":
+		return ev.boolValue(l <= r), nil
+	case "==":
+		return ev.boolValue(l == r), nil
+	case "!=":
+		return ev.boolValue(l != r), nil
+	case "?:", "&&", "||":
+		// the left side didn't short-circuit
+		if op == "?:" {
+			return r, nil
+		}
+		return ev.boolValue(r != 0), nil
+	default:
+		return 0, evalError(ErrSyntax, op,
+			fmt.Sprintf("unknown operator '%s' in ExprASTResult", op))
+	}
+}
+
+// unary applies the prefix operator op, or the postfix one if postfix, to the evaluated v
+func (ev *evaluator) unary(op string, postfix bool, v float64) (float64, error) {
+	switch {
+	case postfix && op == "%":
+		return v / 100, nil
+	case postfix:
+		return factorial(v)
+	case op == "-":
+		return 0 - v, nil
+	case op == "+":
+		return v, nil
+	case op == "~":
+		if v != math.Trunc(v) {
+			return 0, evalError(ErrInvalidOperand, op,
+				fmt.Sprintf("operand %s of '~' is not an integer", Float64ToStr(v)))
+		}
+		i, err := toInt(op, v)
+		return float64(^i), err
+	case op == "!":
+		return ev.boolValue(v == 0), nil
+	}
+	return 0, evalError(ErrSyntax, op,
+		fmt.Sprintf("unknown unary operator '%s' in ExprASTResult", op))
+}
+
+// percentOf reports whether b is "x + p%" or "x - p%", where p% is a percentage of x
+func percentOf(b BinaryExprAST) bool {
+	return (b.Op == "+" || b.Op == "-") && isPercent(b.Rhs)
+}
+
+func isPercent(expr ExprAST) bool {
+	u, ok := expr.(UnaryExprAST)
+	return ok && u.Postfix && u.Op == "%"
+}
+
+// unaryName is the operator of u in Metrics, "x!" for the factorial and "x%" for the percentage
+func unaryName(u UnaryExprAST) string {
+	if u.Postfix {
+		return "x" + u.Op
+	}
+	return u.Op
+}
+
+// maxFactorial is the largest n whose factorial is a finite float64
+const maxFactorial = 170
+
+// factorial is n! of a non-negative integer n
+func factorial(n float64) (float64, error) {
+	if n < 0 || n != math.Trunc(n) {
+		return 0, evalError(ErrDomain, "!",
+			fmt.Sprintf("domain error: the factorial of %s is undefined, want a non-negative integer", Float64ToStr(n)))
+	}
+	if n > maxFactorial {
+		return 0, evalError(ErrOverflow, "!",
+			fmt.Sprintf("the factorial of %s overflows float64", Float64ToStr(n)))
+	}
+	r := 1.0
+	for i := 2.0; i <= n; i++ {
+		r *= i
+	}
+	return r, nil
+}
+
+// undefinedVariableError is the error of a variable neither in vars nor a constant
+func undefinedVariableError(name string, offset int) *EvalError {
+	return &EvalError{
+		Kind:         ErrUnknownIdentifier,
+		Msg:          fmt.Sprintf("variable '%s' is undefined", name),
+		Token:        name,
+		Offset:       offset,
+		undefinedVar: true,
+	}
+}
+
+// isUndefinedVariable reports whether err is an undefinedVariableError
+func isUndefinedVariable(err error) bool {
+	e, ok := err.(*EvalError)
+	return ok && e.undefinedVar
+}
+
+// intOp evaluates the integer operators % ^ >> << & | on the truncated operands
+func intOp(op string, l, r float64) (float64, error) {
+	a, err := toInt(op, l)
+	if err != nil {
+		return 0, err
+	}
+	b, err := toInt(op, r)
+	if err != nil {
+		return 0, err
+	}
+	switch op {
+	case "%":
+		if b == 0 {
+			return 0, evalError(ErrDivisionByZero, op,
+				fmt.Sprintf("violation of arithmetic specification: a modulo by zero in ExprASTResult: [%s%%%s]",
+					Float64ToStr(l),
+					Float64ToStr(r)))
+		}
+		return float64(a % b), nil
+	case "^":
+		return float64(a ^ b), nil
+	case ">>", "<<":
+		if b < 0 {
+			return 0, evalError(ErrInvalidOperand, op,
+				fmt.Sprintf("violation of arithmetic specification: a negative shift count in ExprASTResult: [%s%s%s]",
+					Float64ToStr(l),
+					op,
+					Float64ToStr(r)))
+		}
+		if op == ">>" {
+			return float64(a >> uint64(b)), nil
+		}
+		return float64(a << uint64(b)), nil
+	case "&":
+		return float64(a & b), nil
+	}
+	return float64(a | b), nil
+}
+
+// exactOp adds or subtracts the shortest decimal forms of l and r,
+// so that 0.1 + 0.2 is 0.3 rather than 0.30000000000000004
+func exactOp(op string, l, r float64) float64 {
+	lh, lok := new(big.Float).SetString(Float64ToStr(l))
+	rh, rok := new(big.Float).SetString(Float64ToStr(r))
+	if !lok || !rok || lh.IsInf() || rh.IsInf() {
+		// NaN and Inf follow IEEE 754
+		if op == "+" {
+			return l + r
+		}
+		return l - r
+	}
+	var f *big.Float
+	if op == "+" {
+		f = new(big.Float).Add(lh, rh)
+	} else {
+		f = new(big.Float).Sub(lh, rh)
+	}
+	v, _ := f.Float64()
+	return v
+}
 
-	return 0.0
+// toInt truncates an operand of an integer operator (% ^ >> << & | ~),
+// err is not nil if v is out of the int64 range
+func toInt(op string, v float64) (int64, error) {
+	if !(v >= -(1<<63) && v < 1<<63) {
+		return 0, evalError(ErrOverflow, op,
+			fmt.Sprintf("operand %s of '%s' is out of the integer range", Float64ToStr(v), op))
+	}
+	return int64(v), nil
 }
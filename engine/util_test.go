@@ -1,7 +1,12 @@
 package engine
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 )
@@ -20,9 +25,9 @@ func TestParseAndExecSimple(t *testing.T) {
 		{"-(1+2)*5", -15},
 		{"-(1+2)*5/3", -5},
 		{"1+(-(1+2)*5/3)", -4},
-		{"3^4", 81},
-		{"3^4.5", 140.29611541307906},
-		{"3.5^4.5", 280.7412308013823},
+		{"3**4", 81},
+		{"3**4.5", 140.29611541307906},
+		{"3.5**4.5", 280.7412308013823},
 		{"8%2", 0},
 		{"8%3", 2},
 		{"8%3.5", 2},
@@ -32,7 +37,7 @@ func TestParseAndExecSimple(t *testing.T) {
 		{"1e-2+1e2", 100.01},
 		{"1e-2+1e2*6/3", 200.01},
 		{"(1e-2+1e2)*6/3", 200.02},
-		{"(88*8)+(1+1+1+1)+(6/1.5)-(99%9*(2^4))", 712},
+		{"(88*8)+(1+1+1+1)+(6/1.5)-(99%9*(2**4))", 712},
 		{"1/3*3", 1},
 		{"123_456_789", 123456789},
 		{"123_456_789___", 123456789},
@@ -52,31 +57,31 @@ func TestParseAndExecSimple(t *testing.T) {
 		{"sqrt(4)", 2},
 		{"cbrt(27)", 3},
 		{"sqrt(4) + cbrt(27)", 5},
-		{"sqrt(2^2) + cbrt(3^3)", 5},
-		{"127^2+5/2-sqrt(2^2) + cbrt(3^3)", 16132.5},
+		{"sqrt(2**2) + cbrt(3**3)", 5},
+		{"127**2+5/2-sqrt(2**2) + cbrt(3**3)", 16132.5},
 		{"max(2)", 2},
 		{"max(abs(1)+10)", 11},
 		{"max(abs(1)+10)*2-1", 21},
 		{"max(2,3.5)", 3.5},
-		{"max(2^3,3+abs(-1)*6)", 9},
-		{"max(2^3,3+abs(-1)*6, 20)", 20},
-		{"max(2^3,3+abs(-1)*6,ceil(9.4))", 10},
+		{"max(2**3,3+abs(-1)*6)", 9},
+		{"max(2**3,3+abs(-1)*6, 20)", 20},
+		{"max(2**3,3+abs(-1)*6,ceil(9.4))", 10},
 		{"max(1,2,3,4,5,6,10,7,4,5,6,9.8)", 10},
 		{"min(3.5)", 3.5},
 		{"min(ceil(1.2))", 2},
 		{"min(2,3.5)", 2},
-		{"min(2^3,3+abs(-1)*6)", 8},
-		{"min(2^3,3+abs(-1)*6,1^10)", 1},
+		{"min(2**3,3+abs(-1)*6)", 8},
+		{"min(2**3,3+abs(-1)*6,1**10)", 1},
 		{"min(99.1,0.2,3,4,5,6,10,7,4,5,6,9.8)", 0.2},
-		{"max(2^3,3^2)", 9},
-		{"min(2^3,3^2)", 8},
+		{"max(2**3,3**2)", 9},
+		{"min(2**3,3**2)", 8},
 		{"noerr(1/0)", 0},
 		{"noerr(1/(1-1))", 0},
 		{"0.1+0.2", 0.3},
 		{"0.3-0.1", 0.2},
-		{"10^-1", 0.1},
-		{"10^-2", 0.01},
-		{"10^-1*100", 10},
+		{"10**-1", 0.1},
+		{"10**-2", 0.01},
+		{"10**-1*100", 10},
 		{"10%0", 0},
 	}
 	for _, e := range exprs {
@@ -192,7 +197,8 @@ func TestParseAndExecError(t *testing.T) {
 		"(1+",
 		"1+",
 		"1*",
-		"+2344",
+		"+",
+		"2344 +",
 		"3+(",
 		"4+(90-",
 		"3-(4*7-2)+",
@@ -228,6 +234,19 @@ func TestParseAndExecError(t *testing.T) {
 			t.Error(e, " this is error expr!")
 		}
 	}
+	// an empty input is an error, not an index out of range
+	if _, err := Parse(""); err == nil {
+		t.Error("Parse: empty input should be an error")
+	}
+	if _, err := Compile(""); err == nil {
+		t.Error("Compile: empty input should be an error")
+	}
+	if _, err := ParseAndExecWithConfig("", Config{}); err == nil {
+		t.Error("ParseAndExecWithConfig: empty input should be an error")
+	}
+	if _, err := EvalWithContext(context.Background(), "", Config{}); err == nil {
+		t.Error("EvalWithContext: empty input should be an error")
+	}
 }
 
 func inSlices(target float64, s []float64) bool {
@@ -238,3 +257,1319 @@ func inSlices(target float64, s []float64) bool {
 	}
 	return false
 }
+
+func TestPanicToError(t *testing.T) {
+	err := panicToError("boom")
+	if err == nil || err.Error() != "panic: boom" {
+		t.Error("non-error panic should be wrapped, get: ", err)
+	}
+	e := errors.New("origin")
+	if err := panicToError(e); err != e {
+		t.Error("error panic should be returned as is, get: ", err)
+	}
+
+	type limitPanic struct{ n int }
+	mapped := errors.New("limit exceeded")
+	PanicMapper = func(e interface{}) error {
+		if _, ok := e.(limitPanic); ok {
+			return mapped
+		}
+		return nil
+	}
+	defer func() { PanicMapper = nil }()
+	if err := panicToError(limitPanic{1}); err != mapped {
+		t.Error("PanicMapper should map limitPanic, get: ", err)
+	}
+	if err := panicToError(42); err == nil || err.Error() != "panic: 42" {
+		t.Error("unmapped panic should use the default conversion, get: ", err)
+	}
+}
+
+func TestEvalInto(t *testing.T) {
+	var i int
+	if err := EvalInto("1+2*3", &i); err != nil || i != 7 {
+		t.Error(err, " EvalInto *int: ", i)
+	}
+	var i64 int64
+	if err := EvalInto("1<<40", &i64); err != nil || i64 != 1<<40 {
+		t.Error(err, " EvalInto *int64: ", i64)
+	}
+	var f float64
+	if err := EvalInto("7/2", &f); err != nil || f != 3.5 {
+		t.Error(err, " EvalInto *float64: ", f)
+	}
+	var b bool
+	if err := EvalInto("3>2", &b); err != nil || !b {
+		t.Error(err, " EvalInto *bool: ", b)
+	}
+	if err := EvalInto("3<2", &b); err != nil || b {
+		t.Error(err, " EvalInto *bool: ", b)
+	}
+
+	var i8 int8
+	if err := EvalInto("100+28", &i8); err == nil {
+		t.Error("EvalInto *int8 should overflow, get: ", i8)
+	}
+	if err := EvalInto("2+3", &b); err == nil {
+		t.Error("EvalInto *bool should reject non-bool result")
+	}
+	if err := EvalInto("7/2", &i); err == nil {
+		t.Error("EvalInto *int should reject non-integer result, get: ", i)
+	}
+	if err := EvalInto("1", i); err == nil {
+		t.Error("EvalInto should reject non-pointer target")
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	exprs := []struct {
+		Opts ErrFormatOptions
+		R    string
+	}{
+		{ErrFormatOptions{}, "---\n1+#\n  ^\n---\n"},
+		{ErrFormatOptions{Caret: '↑', Rule: '='}, "===\n1+#\n  ↑\n===\n"},
+		{ErrFormatOptions{OmitRule: true}, "1+#\n  ^\n"},
+		{ErrFormatOptions{Caret: '*', OmitRule: true}, "1+#\n  *\n"},
+	}
+	for _, e := range exprs {
+		if r := FormatError("1+#", 2, e.Opts); r != e.R {
+			t.Error(e, " FormatError:\n", r)
+		}
+	}
+	if ErrPos("1+#", 2) != FormatError("1+#", 2, ErrFormatOptions{}) {
+		t.Error("ErrPos should be FormatError with default options")
+	}
+}
+
+func TestEvalWithMetrics(t *testing.T) {
+	exp := "1 + 2 + 3 * 4"
+	toks, err := Parse(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast := NewAST(toks, exp)
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		t.Fatal(ast.Err)
+	}
+	r, m, err := EvalWithMetrics(ar)
+	if err != nil || r != 15 {
+		t.Error(err, " EvalWithMetrics: ", r)
+	}
+	if len(m.Ops) != 2 || m.Ops["+"] != 2 || m.Ops["*"] != 1 {
+		t.Error(exp, " EvalWithMetrics Ops: ", m.Ops)
+	}
+
+	_, _, err = EvalWithMetrics(BinaryExprAST{Op: "/", Lhs: NumberExprAST{Val: 1}, Rhs: NumberExprAST{}})
+	if err == nil {
+		t.Error("EvalWithMetrics should return division by zero error")
+	}
+}
+
+func TestSpaceGrouping(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"1 234", 1234},
+		{"1 234 567", 1234567},
+		{"1  234 + 2", 1236},
+		{"(1 000) * 2", 2000},
+	}
+	cfg := Config{SpaceGrouping: true}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig SpaceGrouping:", r)
+		}
+	}
+	if _, err := ParseAndExec("1 234"); err == nil {
+		t.Error("1 234 should be an error without SpaceGrouping")
+	}
+	// only the integer part is grouped
+	for _, e := range []string{"1e3 5", "1.5 000"} {
+		if _, err := ParseAndExecWithConfig(e, cfg); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+}
+
+func TestCurrencySymbols(t *testing.T) {
+	cfg := Config{CurrencySymbols: []string{"$", "€"}}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"$100 + $50", 150},
+		{"€100 - €50", 50},
+		{"$100 * 3", 300},
+		{"($1_000 + $500) / 3", 500},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig CurrencySymbols:", r)
+		}
+	}
+	if _, err := ParseAndExecWithConfig("$100 + €50", cfg); err == nil {
+		t.Error("mixing currency symbols should be an error")
+	}
+	if _, err := ParseAndExec("$100 + $50"); err == nil {
+		t.Error("currency symbols should be an error without CurrencySymbols")
+	}
+}
+
+func TestMiddleDotMultiply(t *testing.T) {
+	cfg := Config{MiddleDotMultiply: true}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"3·4", 12},
+		{"3 · 4 + 1", 13},
+		{"2·(3+4)·2", 28},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig MiddleDotMultiply:", r)
+		}
+	}
+	_, err := ParseAndExec("3·4")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Msg != "symbol error: unknown '·', pos [1:]" || pe.Token != "·" {
+		t.Error("middle dot should be an unknown symbol without MiddleDotMultiply, get: ", err)
+	}
+	if errs := Validate("·1 + 2·"); len(errs) != 2 {
+		t.Error(errs, " Validate middle dots")
+	}
+}
+
+func TestImplicitMultiply(t *testing.T) {
+	cfg := Config{ImplicitMultiply: true}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"2(3+4)", 14},
+		{"(1+2)(3+4)", 21},
+		{"(1+2)3", 9},
+		{"3pi", 3 * math.Pi},
+		{"2x", 20},
+		{"2 x + 1", 21},
+		{"(x)(x)", 100},
+		{"2e", 2 * math.E},
+		{"2e2x", 2000},
+		{"1.5e-1", 0.15},
+		{"2^3(2)", 2 ^ 6},
+		{"-2(3)", -6},
+		{"2sqrt(16)", 8},
+		{"max(2, 3)(2)", 6},
+	}
+	for _, e := range exprs {
+		ex, err := CompileWithConfig(e.Expr, cfg)
+		if err != nil {
+			t.Error(err, e)
+			continue
+		}
+		if r, err := ex.Eval(map[string]float64{"x": 10}); err != nil || r != e.R {
+			t.Error(err, e, " ImplicitMultiply:", r)
+		}
+	}
+	for _, e := range []string{"2 3", "x(2)", "2(", "2e"} {
+		if e == "2e" {
+			if _, err := ParseAndExec(e); err == nil {
+				t.Error(e, " should be an error without ImplicitMultiply")
+			}
+			continue
+		}
+		if _, err := ParseAndExecWithConfig(e, cfg); err == nil {
+			t.Error(e, " ImplicitMultiply: want an error")
+		}
+	}
+	if _, err := ParseAndExec("2(3+4)"); err == nil {
+		t.Error("2(3+4) should be an error without ImplicitMultiply")
+	}
+	trivia := Config{ImplicitMultiply: true, KeepTrivia: true}
+	if toks, err := ParseWithConfig(" 2 (3)", trivia); err != nil || ReconstructExact(toks) != " 2 (3)" {
+		t.Error(err, " ImplicitMultiply ReconstructExact")
+	}
+}
+
+func TestPercentLiterals(t *testing.T) {
+	cfg := Config{PercentLiterals: true}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"200 * 10%", 20},
+		{"200 + 10%", 220},
+		{"200 - 10%", 180},
+		{"x + 50%", 15},
+		{"50%", 0.5},
+		{"200 / 10%", 2000},
+		{"(100 + 100) + 10% * 2", 200.2},
+		{"200 + (5 + 5)%", 220},
+		{"200 + -(10%)", 199.9},
+		{"10% + 5", 5.1},
+		{"10 % 3", 1},
+		{"10 % x", 0},
+		{"10 % (4)", 2},
+		{"max(10%, 2)", 2},
+		{"3!%", 0.06},
+		{"x + +x%", 10.1},
+		{"x + --x%", 10.1},
+		{"x + x% * 1", 10.1},
+		{"x - x% / 1", 9.9},
+		{"x + (x% + 0)", 10.1},
+		{"x + (1 ? x% : 0)", 10.1},
+		{"x + (x + 0)%", 11},
+	}
+	for _, e := range exprs {
+		ex, err := CompileWithConfig(e.Expr, cfg)
+		if err != nil {
+			t.Error(err, e)
+			continue
+		}
+		vars := map[string]float64{"x": 10}
+		if r, err := ex.Eval(vars); err != nil || math.Abs(r-e.R) > 1e-9 {
+			t.Error(err, e, " PercentLiterals:", r)
+		}
+		if r, err := ex.Bytecode().Run(vars); err != nil || math.Abs(r-e.R) > 1e-9 {
+			t.Error(err, e, " PercentLiterals Bytecode:", r)
+		}
+		if r, err := (&Expression{Root: Simplify(ex.Root)}).Eval(vars); err != nil || math.Abs(r-e.R) > 1e-9 {
+			t.Error(err, e, " PercentLiterals Simplify:", r)
+		}
+	}
+	if _, err := ParseAndExec("200 + 10%"); err == nil {
+		t.Error("200 + 10% should be an error without PercentLiterals")
+	}
+}
+
+func TestLogicalBitwise(t *testing.T) {
+	cfg := Config{LogicalBitwise: true}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"0 & (1/0)", 0},
+		{"-1 | (1/0)", -1},
+		{"1 | 2", 3},
+		{"1 | 1", 1},
+		{"(2<1) & (1/0)", 0},
+		{"1 & 1", 1},
+		{"1 & 0", 0},
+		{"0 | 1", 1},
+		{"0 | 0", 0},
+		{"6 & 3", 2},
+		{"4 | 3", 7},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig LogicalBitwise:", r)
+		}
+	}
+	if _, err := ParseAndExec("0 & (1/0)"); err == nil {
+		t.Error("0 & (1/0) should evaluate both sides without LogicalBitwise")
+	}
+	for _, e := range []string{"2 & (1/0)", "1 | (1/0)"} {
+		if r, err := ParseAndExecWithConfig(e, cfg); err == nil {
+			t.Error(e, " should evaluate both sides, get: ", r)
+		}
+	}
+}
+
+func TestEvalSubtree(t *testing.T) {
+	exp := "1 + 2 * 3"
+	toks, _ := Parse(exp)
+	ar := NewAST(toks, exp).ParseExpression()
+	exprs := []struct {
+		Path []int
+		R    float64
+	}{
+		{nil, 7},
+		{[]int{0}, 1},
+		{[]int{1}, 6},
+		{[]int{1, 0}, 2},
+		{[]int{1, 1}, 3},
+	}
+	for _, e := range exprs {
+		r, err := EvalSubtree(ar, e.Path)
+		if err != nil || r != e.R {
+			t.Error(err, e, " EvalSubtree:", r)
+		}
+	}
+	for _, path := range [][]int{{2}, {-1}, {0, 0}, {1, 1, 0}} {
+		if _, err := EvalSubtree(ar, path); err == nil {
+			t.Error(path, " should be an invalid path")
+		}
+	}
+}
+
+func TestSaturateLiterals(t *testing.T) {
+	cfg := Config{SaturateLiterals: true}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"1e400", math.MaxFloat64},
+		{"-1e400", -math.MaxFloat64},
+		{"1e400 / 2", math.MaxFloat64 / 2},
+		{"-12", -12},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig SaturateLiterals:", r)
+		}
+	}
+	if _, err := ParseAndExec("1e400"); err == nil {
+		t.Error("out of range literal should be an error without SaturateLiterals")
+	}
+}
+
+func TestTrueFalseValue(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		Cfg  Config
+		R    float64
+	}{
+		{"3 > 2", Config{}, 1},
+		{"3 < 2", Config{}, 0},
+		{"3 > 2", Config{TrueValue: -1}, -1},
+		{"3 < 2", Config{TrueValue: -1}, 0},
+		{"(3 > 2) & (2 > 1)", Config{TrueValue: -1}, -1},
+		{"(3 > 2) & (2 > 5)", Config{TrueValue: -1}, 0},
+		{"(3 > 2) ^ (3 > 2)", Config{TrueValue: -1}, 0},
+		{"3 < 2", Config{TrueValue: 10, FalseValue: 20}, 20},
+		{"(3 > 2) | (1/0)", Config{TrueValue: -1, LogicalBitwise: true}, -1},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, e.Cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig TrueValue/FalseValue:", r)
+		}
+	}
+}
+
+func TestWillPanic(t *testing.T) {
+	exprs := []struct {
+		Expr   string
+		Panics bool
+	}{
+		{"1/0", true},
+		{"5 % 0", true},
+		{"1 << -1", true},
+		{"8 >> (2-3)", true},
+		{"1 + 6/(3-3)", true},
+		{"(1/0) + 1", true},
+		{"1/(2-1)", false},
+		{"5 % 3 + (1 << 2)", false},
+		{"0/5", false},
+		{"0 && 1/0", false},
+		{"1 && 1/0", true},
+		{"1 || 1/0", false},
+		{"0 || 1/0", true},
+	}
+	for _, e := range exprs {
+		toks, _ := Parse(e.Expr)
+		ar := NewAST(toks, e.Expr).ParseExpression()
+		if panics, reason := WillPanic(ar); panics != e.Panics {
+			t.Error(e, " WillPanic: ", panics, reason)
+		}
+	}
+}
+
+func TestMinIntLiteral(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"-9223372036854775808", math.MinInt64},
+		{"(-9223372036854775808) / 2", math.MinInt64 / 2},
+		{"9223372036854775808", -math.MinInt64},
+		{"-9223372036854775808 >> 62", -2},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	if _, err := ParseAndExec("9223372036854775808 >> 1"); err == nil {
+		t.Error("operand out of the integer range should be an error")
+	}
+}
+
+func TestParseTreeLevels(t *testing.T) {
+	exprs := []struct {
+		Expr   string
+		Levels string
+	}{
+		{"1 + 2 * 3 - 4", "[[-] [+] [*]]"},
+		{"1 * 2 + 3 * 4", "[[+] [* *]]"},
+		{"(1 + 2) * 3", "[[*] [+]]"},
+		{"1 << 2 + 3 & 4", "[[&] [<<] [+]]"},
+		{"7", "[]"},
+	}
+	for _, e := range exprs {
+		levels, err := ParseTreeLevels(e.Expr)
+		if r := fmt.Sprint(levels); err != nil || r != e.Levels {
+			t.Error(err, e, " ParseTreeLevels:", r)
+		}
+	}
+}
+
+func TestElvis(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"0 ?: 7", 7},
+		{"3 ?: 7", 3},
+		{"3 ?: 1/0", 3},
+		{"2 - 2 ?: 5", 5},
+		{"0 ?: 0 ?: 9", 9},
+		{"(1 > 2) ?: 4 | 1", 5},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	for _, e := range []string{"0 ?: 1/0", "1 ? 2", "1 ?:"} {
+		if _, err := ParseAndExec(e); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+	for _, e := range []struct {
+		Expr   string
+		Panics bool
+	}{
+		{"3 ?: 1/0", false},
+		{"0 ?: 1/0", true},
+		{"(1/0) ?: 1", true},
+	} {
+		toks, _ := Parse(e.Expr)
+		if panics, _ := WillPanic(NewAST(toks, e.Expr).ParseExpression()); panics != e.Panics {
+			t.Error(e, " WillPanic: ", panics)
+		}
+	}
+	if r, err := ParseAndExecDecimal("0 ?: 1.5", 1); err != nil || r != 15 {
+		t.Error(err, " ParseAndExecDecimal elvis: ", r)
+	}
+}
+
+func TestMaxAbsResult(t *testing.T) {
+	cfg := Config{MaxAbsResult: 1000000}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"1000 * 1000", 1000000},
+		{"-1000 * 1000", -1000000},
+		{"(1 << 10) * 900", 921600},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig MaxAbsResult:", r)
+		}
+	}
+	for _, e := range []string{"1 << 62", "(1 << 40) >> 30", "-1000 * 1001", "2000000"} {
+		_, err := ParseAndExecWithConfig(e, cfg)
+		if err == nil || !strings.Contains(err.Error(), "result magnitude exceeds limit") {
+			t.Error(e, " should exceed MaxAbsResult, get: ", err)
+		}
+	}
+	_, err := ParseAndExecWithConfig("2e300", cfg)
+	if err == nil || err.Error() != "result magnitude exceeds limit: 2e+300 is outside [-1e+06, 1e+06]" {
+		t.Error(err, " MaxAbsResult message")
+	}
+}
+
+func TestForbidChainedComparisons(t *testing.T) {
+	cfg := Config{ForbidChainedComparisons: true}
+	for _, e := range []string{"1 < 2 < 3", "3 > 2 > 1", "1 < 2 * 3 < 4", "1 - 2 < 3 > 0"} {
+		_, err := ParseAndExecWithConfig(e, cfg)
+		if err == nil || !strings.Contains(err.Error(), "comparison operators cannot be chained") {
+			t.Error(e, " should be a chained comparison error, get: ", err)
+		}
+		if _, err := ParseAndExec(e); err != nil {
+			t.Error(e, " should be allowed by default: ", err)
+		}
+	}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"(1 < 2) < 3", 1},
+		{"1 < (2 < 3)", 0},
+		{"(1 < 2) & (2 < 3)", 1},
+		{"1 + 2 > 2", 1},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWithConfig(e.Expr, cfg)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWithConfig ForbidChainedComparisons:", r)
+		}
+	}
+}
+
+func TestParseAndExecFloat(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"3.5 * 2", 7},
+		{"7 / 2", 3.5},
+		{"0.1 + 0.2", 0.3},
+		{"1.5e2 - 0.5", 149.5},
+		{"8 % 3.5", 2},
+		{"6.9 | 1", 7},
+		{"2.5 > 2.4", 1},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	for _, e := range []string{"1e300 & 1", "1 << 1e19", "0.0.9"} {
+		if _, err := ParseAndExec(e); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+}
+
+func TestFunCaller(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"sqrt(16)", 4},
+		{"pow(2, 10)", 1024},
+		{"max(sqrt(9), abs(-4))", 4},
+		{"min(3, 1, 2) + max(1)", 2},
+		{"-abs(-2) * cos(0)", -2},
+		{"noerr(1/0) + 1", 1},
+		{"sin(0)", 0},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"pow(2)", "parameters want 2 but get 1"},
+		{"max()", "parameters want at least 1 but get 0"},
+		{"foo(1)", "function 'foo' is undefined"},
+		{"sqrt 4", "bad expression, reaching the end or missing the operator"},
+		{"sqrt(4", "want ')' but get EOF"},
+		{"max(1 2)", "want ',' or ')' but get 2"},
+		{"max(1,)", "want '(' or '0-9' but get ')'"},
+	}
+	for _, e := range errs {
+		_, err := ParseAndExec(e.Expr)
+		if err == nil || !strings.Contains(err.Error(), e.Err) {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+}
+
+func TestParseAndExecWith(t *testing.T) {
+	vars := map[string]float64{"x": 41, "y": 0.5, "pi": 3}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"x+1", 42},
+		{"x * 2 + y", 82.5},
+		{"-x", -41},
+		{"max(x, y) - sqrt(4)", 39},
+		{"pi", 3},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWith(e.Expr, vars)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWith:", r)
+		}
+	}
+	if r, err := ParseAndExecWith("pi", nil); err != nil || r != math.Pi {
+		t.Error(err, " ParseAndExecWith pi:", r)
+	}
+	_, err := ParseAndExecWith("x + z", vars)
+	if err == nil || !strings.HasPrefix(err.Error(), "undefined variables: z\n'z' pos [4:]\n") {
+		t.Error(err, " ParseAndExecWith undefined variable")
+	}
+	// every undefined variable is listed with its positions
+	_, err = ParseAndExecWith("a * x + b - a", vars)
+	if err == nil || !strings.HasPrefix(err.Error(), "undefined variables: a, b\n") ||
+		strings.Count(err.Error(), " pos [") != 3 || !strings.Contains(err.Error(), "'a' pos [12:]") {
+		t.Error(err, " ParseAndExecWith undefined variables")
+	}
+
+	toks, _ := Parse("x*x")
+	ast := NewAST(toks, "x*x")
+	ar := ast.ParseExpression()
+	if r := ExprASTResultWith(ar, map[string]float64{"x": 3}); r != 9 {
+		t.Error(ar, " ExprASTResultWith:", r)
+	}
+
+	// the arguments of a RegFunction see the variables
+	_ = RegFunction("vardouble", 1, func(expr ...ExprAST) float64 {
+		return ExprASTResult(expr[0]) * 2
+	})
+	defer Unregister("vardouble")
+	if r, err := ParseAndExecWith("vardouble(x + 1)", map[string]float64{"x": 3}); err != nil || r != 8 {
+		t.Error(err, " ParseAndExecWith RegFunction:", r)
+	}
+	e, _ := Compile("vardouble(x)")
+	if r, err := e.Eval(map[string]float64{"x": 3}); err != nil || r != 6 {
+		t.Error(err, " Expression.Eval RegFunction:", r)
+	}
+}
+
+func TestExprASTResultOperators(t *testing.T) {
+	if r, err := ParseAndExec("6 | 1"); err != nil || r != 7 {
+		t.Error(err, " ParseAndExec 6 | 1:", r)
+	}
+	// every operator the parser builds is evaluated
+	for op := range precedence {
+		ast := BinaryExprAST{Op: op, Lhs: NumberExprAST{Val: 6}, Rhs: NumberExprAST{Val: 1}}
+		if _, err := EvalSubtree(ast, nil); err != nil {
+			t.Error(err, " ExprASTResult: ", op)
+		}
+	}
+	ast := BinaryExprAST{Op: "$", Lhs: NumberExprAST{Val: 6}, Rhs: NumberExprAST{Val: 1}}
+	if _, err := EvalSubtree(ast, nil); err == nil || err.Error() != "unknown operator '$' in ExprASTResult" {
+		t.Error(err, " ExprASTResult unknown operator")
+	}
+}
+
+func TestEvalWithAudit(t *testing.T) {
+	s := "max(x, y * 2) + x - pi"
+	toks, _ := Parse(s)
+	ast := NewAST(toks, s)
+	ar := ast.ParseExpression()
+	r, rec, err := EvalWithAudit(ar, map[string]float64{"x": 3, "y": 2})
+	if err != nil || r != 7-math.Pi || rec.Result != r {
+		t.Error(err, " EvalWithAudit:", r, rec)
+	}
+	reads := []VariableRead{{"x", 3}, {"y", 2}, {"x", 3}, {"pi", math.Pi}}
+	if fmt.Sprint(rec.Reads) != fmt.Sprint(reads) {
+		t.Error(rec.Reads, " EvalWithAudit Reads want ", reads)
+	}
+	calls := []FunctionCall{{"max", []float64{3, 4}}}
+	if fmt.Sprint(rec.Calls) != fmt.Sprint(calls) {
+		t.Error(rec.Calls, " EvalWithAudit Calls want ", calls)
+	}
+
+	// a RegFunction call is recorded with its evaluated arguments
+	_ = RegFunction("auditdouble", 1, func(expr ...ExprAST) float64 {
+		return ExprASTResult(expr[0]) * 2
+	})
+	defer Unregister("auditdouble")
+	s = "auditdouble(x + 1) - 1"
+	toks, _ = Parse(s)
+	r, rec, err = EvalWithAudit(NewAST(toks, s).ParseExpression(), map[string]float64{"x": 3})
+	calls = []FunctionCall{{"auditdouble", []float64{4}}}
+	if err != nil || r != 7 || rec.Result != 7 || fmt.Sprint(rec.Calls) != fmt.Sprint(calls) {
+		t.Error(err, " EvalWithAudit RegFunction:", r, rec)
+	}
+
+	_, rec, err = EvalWithAudit(BinaryExprAST{Op: "+", Lhs: VariableExprAST{Name: "x"}, Rhs: VariableExprAST{Name: "z"}},
+		map[string]float64{"x": 1})
+	if err == nil || len(rec.Reads) != 1 {
+		t.Error(err, " EvalWithAudit undefined variable:", rec)
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	RegisterFunc("celsius", func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, errors.New("celsius wants 1 argument")
+		}
+		return (args[0] - 32) * 5 / 9, nil
+	})
+	defer Unregister("celsius")
+	if r, err := ParseAndExec("celsius(212) + 1"); err != nil || r != 101 {
+		t.Error(err, " ParseAndExec celsius:", r)
+	}
+	if _, err := ParseAndExec("celsius(1, 2)"); err == nil || err.Error() != "celsius wants 1 argument" {
+		t.Error(err, " RegisterFunc error should be returned")
+	}
+
+	// a registered function takes precedence over a built-in
+	RegisterFunc("abs", func(args []float64) (float64, error) {
+		return -1, nil
+	})
+	if r, err := ParseAndExec("abs(-2)"); err != nil || r != -1 {
+		t.Error(err, " ParseAndExec registered abs:", r)
+	}
+	Unregister("abs")
+	if r, err := ParseAndExec("abs(-2)"); err != nil || r != 2 {
+		t.Error(err, " ParseAndExec built-in abs:", r)
+	}
+	for _, name := range []string{"", "1x", "a-b"} {
+		if err := RegisterFunc(name, func(args []float64) (float64, error) { return 0, nil }); err == nil {
+			t.Error(name, " RegisterFunc: want an invalid name error")
+		}
+	}
+
+	e, _ := Compile("celsius(x)")
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func(x float64) {
+			for j := 0; j < 100; j++ {
+				if r, err := e.Eval(map[string]float64{"x": x}); err != nil || r != (x-32)*5/9 {
+					t.Error(err, " Expression.Eval celsius:", r)
+				}
+			}
+			done <- true
+		}(float64(i * 10))
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+
+	Unregister("celsius")
+	if _, err := e.Eval(map[string]float64{"x": 1}); err == nil {
+		t.Error("an unregistered function should be an error")
+	}
+}
+
+func TestEvalAST(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"1/0", "violation of arithmetic specification: a division by zero in ExprASTResult: [1/0]"},
+		{"8%(2-2)", "violation of arithmetic specification: a modulo by zero in ExprASTResult: [8%0]"},
+		{"5 % 0", "violation of arithmetic specification: a modulo by zero in ExprASTResult: [5%0]"},
+		{"1<<-1", "violation of arithmetic specification: a negative shift count in ExprASTResult: [1<<-1]"},
+		{"8>>(1-3)", "violation of arithmetic specification: a negative shift count in ExprASTResult: [8>>-2]"},
+		{"1 + max(2, 3/0)", "violation of arithmetic specification: a division by zero in ExprASTResult: [3/0]"},
+	}
+	for _, e := range exprs {
+		toks, _ := Parse(e.Expr)
+		ast := NewAST(toks, e.Expr)
+		ar := ast.ParseExpression()
+		if _, err := EvalAST(ar); err == nil || err.Error() != e.Err {
+			t.Error(err, e, " EvalAST")
+		}
+		if _, err := ParseAndExec(e.Expr); err == nil || err.Error() != e.Err {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+	toks, _ := Parse("7%3 + (1<<3)")
+	ar := NewAST(toks, "7%3 + (1<<3)").ParseExpression()
+	if r, err := EvalAST(ar); err != nil || r != 9 {
+		t.Error(err, " EvalAST:", r)
+	}
+}
+
+func TestEval(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"1/0", "violation of arithmetic specification: a division by zero in ExprASTResult: [1/0]"},
+		{"1e21 / 0", "violation of arithmetic specification: a division by zero in ExprASTResult: [1000000000000000000000/0]"},
+		{"sqrt(-4)", "domain error: sqrt(-4) has no finite real result"},
+		{"~0.5", "operand 0.5 of '~' is not an integer"},
+	}
+	for _, e := range exprs {
+		toks, _ := Parse(e.Expr)
+		ar := NewAST(toks, e.Expr).ParseExpression()
+		if _, err := Eval(ar); err == nil || err.Error() != e.Err {
+			t.Error(err, e, " Eval")
+		}
+	}
+	toks, _ := Parse("2 ** 10 / 4")
+	ar := NewAST(toks, "2 ** 10 / 4").ParseExpression()
+	r, err := Eval(ar)
+	if i, ok := r.Int(); err != nil || !ok || i != 256 || r.String() != "256" {
+		t.Error(err, " Eval:", r)
+	}
+	if _, ok := (Result{Value: 2.5}).Int(); ok {
+		t.Error("2.5 isn't an integer Result")
+	}
+}
+
+func TestConstants(t *testing.T) {
+	if err := RegisterConst("gravity", 9.80665); err != nil {
+		t.Fatal(err, " RegisterConst")
+	}
+	defer UnregisterConst("gravity")
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"pi", math.Pi},
+		{"e", math.E},
+		{"tau / 2", math.Pi},
+		{"phi", math.Phi},
+		{"ln(e)", 1},
+		{"2 * gravity", 2 * 9.80665},
+		{"max(gravity, tau)", 9.80665},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	if r, err := ParseAndExecWith("gravity", map[string]float64{"gravity": 10}); err != nil || r != 10 {
+		t.Error(err, " a variable should take precedence over a constant:", r)
+	}
+	for _, name := range []string{"pi", "e", "1g", "", "g-2"} {
+		if err := RegisterConst(name, 1); err == nil {
+			t.Error(name, " RegisterConst should fail")
+		}
+	}
+
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"sqr(4)", "function 'sqr' is undefined, did you mean 'sqrt'?"},
+		{"2 * gravty", "'gravty' pos [4:], did you mean 'gravity'?"},
+		{"tua", "'tua' pos [0:], did you mean 'tau'?"},
+		{"zzz(1)", "function 'zzz' is undefined\n"},
+		{"x + 1", "'x' pos [0:]\n"},
+	}
+	for _, e := range errs {
+		_, err := ParseAndExec(e.Expr)
+		if err == nil || !strings.Contains(err.Error(), e.Err) {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+	toks, _ := Parse("2 * gravty")
+	ar := NewAST(toks, "2 * gravty").ParseExpression()
+	if _, err := EvalAST(ar); err == nil || err.Error() != "variable 'gravty' is undefined, did you mean 'gravity'?" {
+		t.Error(err, " EvalAST suggestion")
+	}
+}
+
+func TestComparisonOperators(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"2 >= 2", 1},
+		{"1 >= 2", 0},
+		{"2 <= 2", 1},
+		{"3 <= 2", 0},
+		{"3 == 3", 1},
+		{"3 == 4", 0},
+		{"3 != 3", 0},
+		{"3 != 4", 1},
+		{"1 + 1 == 2", 1},
+		{"(2 > 1) == (1 < 2)", 1},
+		{"1 & 2 == 2", 1},
+		{"3>=2>>1", 1},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	for _, e := range []string{"1 = 1", "1 ! 1", "1 =! 1", "1 >== 1"} {
+		if _, err := ParseAndExec(e); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+}
+
+func TestRadixLiterals(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"0xFF", 255},
+		{"0b1010", 10},
+		{"0o17", 15},
+		{"0xF0 | 0x0F", 255},
+		{"0xFF & 0b1111 + 1", 16},
+		{"0x_FF_FF", 65535},
+		{"-0x10", -16},
+		{"0XA + 0B1 + 0O1", 12},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	for _, e := range []string{"0x", "0b102", "0o8", "0xG", "0xFFFFFFFFFFFFFFFFF"} {
+		if _, err := ParseAndExec(e); err == nil || !strings.Contains(err.Error(), "invalid integer literal") {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+}
+
+func TestScientificLiterals(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"1e6", 1e6},
+		{"2.5e-3", 2.5e-3},
+		{"1E+2", 100},
+		{"3E2 - 1e2", 200},
+		{"1e3 >> 2", 250},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"1e", "invalid number literal '1e': the exponent has no digits"},
+		{"2E-", "invalid number literal '2E-': the exponent has no digits"},
+		{"1e2.5", "invalid number literal '1e2.5': the exponent must be an integer"},
+		{"1.2.3", "invalid number literal '1.2.3': more than one decimal point"},
+		{"0x", "invalid integer literal '0x': no digits after '0x'"},
+		{"0b102", "invalid integer literal '0b102': '2' is not a binary digit"},
+		{"0o78", "invalid integer literal '0o78': '8' is not an octal digit"},
+		{"0x1FFFFFFFFFFFFFFFF", "invalid integer literal '0x1FFFFFFFFFFFFFFFF': it overflows int64"},
+	}
+	for _, e := range errs {
+		_, err := ParseAndExec(e.Expr)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Msg != e.Err {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+}
+
+func TestBitwiseNot(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"~0", -1},
+		{"~(1 << 3)", -9},
+		{"~1 & 3", 2},
+		{"~~5", 5},
+		{"~-1", 0},
+		{"0xFF & ~0x0F", 240},
+		{"1 - ~0", 2},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"~1.5", "operand 1.5 of '~' is not an integer"},
+		{"~", "want '0-9' but get '~'"},
+		{"1 ~ 2", "bad expression"},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExec(e.Expr); err == nil || !strings.Contains(err.Error(), e.Err) {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+}
+
+func TestPowerOperator(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"2 ** 3", 8},
+		{"2 ** 3 ** 2", 512},
+		{"(2 ** 3) ** 2", 64},
+		{"2 * 3 ** 2", 18},
+		{"3 ** 2 * 2", 18},
+		{"2 ** 2 * 3 ** 2", 36},
+		{"8 / 2 ** 2", 2},
+		{"2 ** -1", 0.5},
+		{"4 ** 0.5 + 1", 3},
+		{"2 ^ 3", 1},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	toks, _ := Parse("2 ** 3 ** 2")
+	ar := NewAST(toks, "2 ** 3 ** 2").ParseExpression()
+	if s := ExprASTToString(ar); s != "(2 ** (3 ** 2))" {
+		t.Error(" ** should be right-associative: ", s)
+	}
+	for _, e := range []string{"2 ***", "2 *** 3", "** 2"} {
+		if _, err := ParseAndExec(e); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+}
+
+func TestFunCallerDomain(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"log(1000)", 3},
+		{"ln(1)", 0},
+		{"tan(0) + floor(2.5) + ceil(2.5)", 5},
+		{"sqrt(0)", 0},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"sqrt(-1)", "domain error: sqrt(-1) has no finite real result"},
+		{"1 + ln(0)", "domain error: ln(0) has no finite real result"},
+		{"log(-10)", "domain error: log(-10) has no finite real result"},
+		{"pow(-8, 1/3)", "domain error: pow(-8, 0.3333333333333333) has no finite real result"},
+		{"pow(0, -1)", "domain error: pow(0, -1) has no finite real result"},
+		{"pow(10, 400)", "the result of pow(10, 400) overflows float64"},
+		{"pow(-10, 401)", "the result of pow(-10, 401) overflows float64"},
+		{"10 ** 400", "the result of pow(10, 400) overflows float64"},
+		{"ln(1, 2)", "parameters want 1 but get 2"},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExec(e.Expr); err == nil || !strings.Contains(err.Error(), e.Err) {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+	if r, err := ParseAndExec("noerr(sqrt(-1))"); err != nil || r != 0 {
+		t.Error(err, " noerr domain error:", r)
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	err := RegisterFunction("clamp", 3, func(args ...float64) (float64, error) {
+		if args[1] > args[2] {
+			return 0, errors.New("clamp: min is greater than max")
+		}
+		return math.Max(args[1], math.Min(args[0], args[2])), nil
+	})
+	if err != nil {
+		t.Fatal(err, " RegisterFunction")
+	}
+	defer Unregister("clamp")
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"clamp(5, 0, 10)", 5},
+		{"clamp(-5, 0, 10)", 0},
+		{"clamp(15, 0, 10) * 2", 20},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"clamp(1, 2)", "wrong way calling function 'clamp', parameters want 3 but get 2"},
+		{"clamp(1, 10, 0)", "clamp: min is greater than max"},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExec(e.Expr); err == nil || !strings.Contains(err.Error(), e.Err) {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+
+	invalid := []struct {
+		Name string
+		Argc int
+	}{
+		{"", 1},
+		{"1abc", 1},
+		{"a_b", 1},
+		{"ok", -2},
+	}
+	for _, f := range invalid {
+		if err := RegisterFunction(f.Name, f.Argc, nil); err == nil {
+			t.Error(f, " RegisterFunction should fail")
+		}
+	}
+	if err := RegFunction("sqrt", 1, nil); err == nil {
+		t.Error("RegFunction should not replace a built-in")
+	}
+}
+
+func TestUnaryOperators(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"5! + 1", 121},
+		{"0!", 1},
+		{"3!!", 720},
+		{"-3!", -6},
+		{"2 ** 3!", 64},
+		{"(1 + 2)! * 2", 12},
+		{"-2 ** 2", -4},
+		{"(-2) ** 2", 4},
+		{"-2 ** 2 ** 3", -256},
+		{"2 ** -1", 0.5},
+		{"2 * -3", -6},
+		{"-2 * 3 + 1", -5},
+		{"+2344", 2344},
+		{"+-3", -3},
+		{"1 - +2", -1},
+		{"~5", -6},
+		{"~2 ** 2", -5},
+		{"!0 + !3!", 1},
+		{"5!=3", 1},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExec(e.Expr)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExec:", r)
+		}
+	}
+	for _, e := range []string{"(-1)!", "2.5!", "171!", "!", "3 +!"} {
+		if _, err := ParseAndExec(e); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+	if r, err := ParseAndExecChecked("20! / 19! + -(2 * 3)"); err != nil || r != 14 {
+		t.Error(err, " ParseAndExecChecked unary:", r)
+	}
+	if _, err := ParseAndExecChecked("21!"); !errors.Is(err, ErrOverflow) {
+		t.Error(err, " ParseAndExecChecked factorial overflow")
+	}
+	if r, err := ParseAndExecBig("25! + ~0"); err != nil || r.String() != "15511210043330985983999999" {
+		t.Error(err, " ParseAndExecBig factorial:", r)
+	}
+	if r, err := ParseAndExecDecimal("4! - +0.5", 2); err != nil || r != 2350 {
+		t.Error(err, " ParseAndExecDecimal factorial:", r)
+	}
+	if _, err := ParseAndExecDecimal("2.5!", 2); !errors.Is(err, ErrDomain) {
+		t.Error(err, " ParseAndExecDecimal factorial of a fraction")
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	vars := map[string]float64{"x": 0, "y": 5}
+	exprs := []struct {
+		Expr string
+		R    float64
+	}{
+		{"1 && 2", 1},
+		{"1 && 0", 0},
+		{"0 || 3", 1},
+		{"0 || 0", 0},
+		{"!0", 1},
+		{"!5", 0},
+		{"!!5", 1},
+		{"!(1 > 2)", 1},
+		{"x != 0 && 10/x > 2", 0},
+		{"x == 0 || 10/x > 2", 1},
+		{"y != 0 && 10/y > 2", 0},
+		{"y > 1 && y < 10", 1},
+		{"1 || 0 && 0", 1},
+		{"0 && 1 || 1", 1},
+		{"1 | 2 && 0", 0},
+		{"1 < 2 && 2 < 3 ?: 7", 1},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWith(e.Expr, vars)
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWith:", r)
+		}
+	}
+	if _, err := ParseAndExecWith("y != 0 && 10/x > 2", vars); err == nil {
+		t.Error("the right side of && should be evaluated when the left is true")
+	}
+	if r, err := ParseAndExecWithConfig("2 > 1 && 3 > 2", Config{TrueValue: -1}); err != nil || r != -1 {
+		t.Error(err, " && TrueValue:", r)
+	}
+	if r, err := ParseAndExecChecked("0 && 1/0 || !0"); err != nil || r != 1 {
+		t.Error(err, " ParseAndExecChecked logical:", r)
+	}
+	for _, e := range []string{"1 &&", "|| 1", "1 ! 2"} {
+		if _, err := ParseAndExec(e); err == nil {
+			t.Error(e, " this is error expr!")
+		}
+	}
+}
+
+func TestConditional(t *testing.T) {
+	exprs := []struct {
+		Expr string
+		X    float64
+		R    float64
+	}{
+		{"x > 100 ? x * 2 : x / 2", 200, 400},
+		{"x > 100 ? x * 2 : x / 2", 50, 25},
+		{"x ? 1 : 2", 0, 2},
+		{"x == 0 ? 0 : 10 / x", 0, 0},
+		{"x < 0 ? -1 : x > 0 ? 1 : 0", -5, -1},
+		{"x < 0 ? -1 : x > 0 ? 1 : 0", 5, 1},
+		{"x < 0 ? -1 : x > 0 ? 1 : 0", 0, 0},
+		{"1 + (x ? 2 : 3) * 2", 1, 5},
+		{"max(x ? 2 : 3, 1)", 0, 3},
+		{"x ?: 2 ? 3 : 4", 0, 3},
+		{"x ? 1 ? 2 : 3 : 4", 1, 2},
+	}
+	for _, e := range exprs {
+		r, err := ParseAndExecWith(e.Expr, map[string]float64{"x": e.X})
+		if err != nil || r != e.R {
+			t.Error(err, e, " ParseAndExecWith:", r)
+		}
+	}
+	errs := []struct {
+		Expr string
+		Err  string
+	}{
+		{"1 ? 2", "want ':' but get EOF"},
+		{"1 ? 2 3", "want ':' but get 3"},
+		{"1 ? 2 :", "want '(' or '0-9' but get EOF"},
+		{"1 ?", "want '(' or '0-9' but get EOF"},
+		{"1 : 2", "bad expression"},
+		{"1 ? 2 : 3 4", "bad expression"},
+	}
+	for _, e := range errs {
+		if _, err := ParseAndExec(e.Expr); err == nil || !strings.Contains(err.Error(), e.Err) {
+			t.Error(err, e, " ParseAndExec")
+		}
+	}
+	for _, e := range []struct {
+		Expr   string
+		Panics bool
+	}{{"1 ? 2 : 1/0", false}, {"0 ? 2 : 1/0", true}, {"(1/0) ? 1 : 2", true}} {
+		toks, _ := Parse(e.Expr)
+		if panics, _ := WillPanic(NewAST(toks, e.Expr).ParseExpression()); panics != e.Panics {
+			t.Error(e, " WillPanic:", panics)
+		}
+	}
+	if r, err := ParseAndExecChecked("2 > 1 ? 7 / 2 : 1 / 0"); err != nil || r != 3 {
+		t.Error(err, " ParseAndExecChecked conditional:", r)
+	}
+}
@@ -0,0 +1,247 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// opcode is an instruction of the stack VM of Bytecode
+type opcode uint8
+
+const (
+	// opConst pushes num
+	opConst opcode = iota
+	// opVar pushes the variable str at offset n
+	opVar
+	// opBinary pops r and l, pushes l str r, or l str l*r if n is 1 for "x + p%"
+	opBinary
+	// opUnary replaces the top v with str v, or with v str if n is 1
+	opUnary
+	// opShort leaves the top l on the stack, if the operator str
+	// is decided by l it replaces the top with the result and jumps to n
+	opShort
+	// opJumpIfZero pops the top, jumps to n if it is 0
+	opJumpIfZero
+	// opJump jumps to n
+	opJump
+	// opCall pops n arguments, pushes the result of the function str
+	opCall
+	// opWalk pushes the result of the tree walker on the subtree n
+	opWalk
+)
+
+// instr is a single VM instruction, the meaning of its operands depends on op
+type instr struct {
+	op  opcode
+	num float64
+	str string
+	n   int
+	// steps are the AST nodes entered before the instruction, counted
+	// against Config.MaxSteps in the order of the tree walker
+	steps int
+}
+
+// Bytecode is an ExprAST compiled to a flat instruction slice,
+// Run evaluates it on a stack VM without walking the tree.
+// the calls of noerr, which needs its unevaluated argument,
+// fall back to the tree walker
+type Bytecode struct {
+	code     []instr
+	walk     []ExprAST
+	maxStack int
+	cfg      Config
+	expr     *Expression
+}
+
+// CompileBytecode is a Top level function
+// compiles expr for the stack VM
+func CompileBytecode(expr ExprAST) *Bytecode {
+	return compileBytecode(expr, Config{})
+}
+
+// Bytecode compiles the expression for the stack VM
+// the result is safe to Run concurrently
+func (e *Expression) Bytecode() *Bytecode {
+	b := compileBytecode(e.Root, e.cfg)
+	b.expr = e
+	return b
+}
+
+func compileBytecode(expr ExprAST, cfg Config) *Bytecode {
+	c := &bytecodeCompiler{b: &Bytecode{cfg: cfg}}
+	c.compile(expr)
+	return c.b
+}
+
+// bytecodeCompiler tracks the stack depth while emitting the instructions
+type bytecodeCompiler struct {
+	b     *Bytecode
+	depth int
+	// nodes entered since the last instruction
+	steps int
+}
+
+func (c *bytecodeCompiler) emit(in instr, push int) int {
+	in.steps, c.steps = c.steps, 0
+	c.b.code = append(c.b.code, in)
+	c.depth += push
+	if c.depth > c.b.maxStack {
+		c.b.maxStack = c.depth
+	}
+	return len(c.b.code) - 1
+}
+
+func (c *bytecodeCompiler) compile(expr ExprAST) {
+	// the tree walker counts a node before its operands,
+	// the step goes with the first instruction of its code
+	c.steps++
+	switch expr.(type) {
+	case NumberExprAST:
+		c.emit(instr{op: opConst, num: expr.(NumberExprAST).Val}, 1)
+	case VariableExprAST:
+		v := expr.(VariableExprAST)
+		c.emit(instr{op: opVar, str: v.Name, n: v.Offset}, 1)
+	case BinaryExprAST:
+		ast := expr.(BinaryExprAST)
+		c.compile(ast.Lhs)
+		short := -1
+		switch ast.Op {
+		case "?:", "&&", "||", "&", "|":
+			short = c.emit(instr{op: opShort, str: ast.Op}, 0)
+		}
+		c.compile(ast.Rhs)
+		in := instr{op: opBinary, str: ast.Op}
+		if percentOf(ast) {
+			in.n = 1
+		}
+		c.emit(in, -1)
+		if short >= 0 {
+			c.b.code[short].n = len(c.b.code)
+		}
+	case UnaryExprAST:
+		u := expr.(UnaryExprAST)
+		c.compile(u.Operand)
+		in := instr{op: opUnary, str: u.Op}
+		if u.Postfix {
+			in.n = 1
+		}
+		c.emit(in, 0)
+	case ConditionalExprAST:
+		ast := expr.(ConditionalExprAST)
+		c.compile(ast.Cond)
+		jz := c.emit(instr{op: opJumpIfZero}, -1)
+		c.compile(ast.Then)
+		jmp := c.emit(instr{op: opJump}, -1)
+		c.b.code[jz].n = len(c.b.code)
+		c.compile(ast.Else)
+		c.b.code[jmp].n = len(c.b.code)
+	case FunCallerExprAST:
+		f := expr.(FunCallerExprAST)
+		def, user, ok := lookupFunc(f.Name)
+		if ok && user == nil && def.fun == nil {
+			c.walkNode(expr)
+			return
+		}
+		for _, arg := range f.Arg {
+			c.compile(arg)
+		}
+		c.emit(instr{op: opCall, str: f.Name, n: len(f.Arg)}, 1-len(f.Arg))
+	default:
+		c.walkNode(expr)
+	}
+}
+
+func (c *bytecodeCompiler) walkNode(expr ExprAST) {
+	c.b.walk = append(c.b.walk, expr)
+	c.emit(instr{op: opWalk, n: len(c.b.walk) - 1}, 1)
+}
+
+// Run evaluates the bytecode, the variables are looked up in vars
+// it returns the same results and errors as Expression.Eval
+func (b *Bytecode) Run(vars map[string]float64) (r float64, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			r, err = 0, panicToError(e)
+		}
+	}()
+	r, err = b.run(&evaluator{cfg: b.cfg, vars: vars})
+	if b.expr != nil {
+		return r, b.expr.evalError(err, vars)
+	}
+	return r, err
+}
+
+func (b *Bytecode) run(ev *evaluator) (float64, error) {
+	stack := make([]float64, 0, b.maxStack)
+	for pc := 0; pc < len(b.code); pc++ {
+		in := &b.code[pc]
+		for i := 0; i < in.steps; i++ {
+			if err := ev.step(); err != nil {
+				return 0, err
+			}
+		}
+		var v float64
+		var err error
+		switch in.op {
+		case opConst:
+			v = in.num
+		case opVar:
+			v, err = ev.variable(in.str, in.n)
+		case opBinary:
+			l, r := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-2]
+			if in.n == 1 {
+				r = l * r
+			}
+			v, err = ev.binary(in.str, l, r)
+		case opUnary:
+			top := len(stack) - 1
+			v, err = ev.unary(in.str, in.n == 1, stack[top])
+			stack = stack[:top]
+		case opShort:
+			top := len(stack) - 1
+			s, ok := ev.shortCircuit(in.str, stack[top])
+			if !ok {
+				continue
+			}
+			if err := ev.limit(s); err != nil {
+				return 0, err
+			}
+			stack[top] = s
+			pc = in.n - 1
+			continue
+		case opJumpIfZero:
+			top := len(stack) - 1
+			if stack[top] == 0 {
+				pc = in.n - 1
+			}
+			stack = stack[:top]
+			continue
+		case opJump:
+			pc = in.n - 1
+			continue
+		case opCall:
+			def, user, ok := lookupFunc(in.str)
+			if !ok {
+				return 0, evalError(ErrUnknownIdentifier, in.str,
+					fmt.Sprintf("function '%s' is undefined", in.str))
+			}
+			if err := checkArgc(in.str, def, in.n); err != nil {
+				return 0, err
+			}
+			args := make([]float64, in.n)
+			copy(args, stack[len(stack)-in.n:])
+			stack = stack[:len(stack)-in.n]
+			v, err = ev.call(in.str, def, user, args)
+		case opWalk:
+			v, err = ev.evalNode(b.walk[in.n])
+		}
+		if err == nil {
+			err = ev.limit(v)
+		}
+		if err != nil {
+			return 0, err
+		}
+		stack = append(stack, v)
+	}
+	return stack[0], nil
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBytecode(t *testing.T) {
+	vars := map[string]float64{"x": 3, "y": -2.5, "z": 0}
+	exprs := []string{
+		"1",
+		"x * 2 + y",
+		"-x + 0.1 + 0.2",
+		"(x + y) * (x - y) / 2",
+		"x ** 2 ** 0.5",
+		"x % 2 + 7 ^ 3 + (1 << 4) + (x & 1) + (x | 4)",
+		"~x + !z + !x",
+		"x! + -x ** 2 + +y",
+		"y!",
+		"x > y && y < z || z == 0",
+		"z && 1/z",
+		"x || 1/z",
+		"z ?: x",
+		"x ?: 1/z",
+		"x > 2 ? y : 1/z",
+		"z ? 1/z : x < 2 ? 1 : 2",
+		"max(x, y, 1) + min(2, x) - abs(y)",
+		"sin(pi / 2) + sqrt(x * x)",
+		"noerr(1/z) + noerr(x)",
+		"round(x * 1.5) + floor(y)",
+	}
+	for _, s := range exprs {
+		e, err := Compile(s)
+		if err != nil {
+			t.Fatal(err, s, " Compile")
+		}
+		walk, werr := e.Eval(vars)
+		vm, verr := e.Bytecode().Run(vars)
+		if walk != vm || (werr == nil) != (verr == nil) {
+			t.Error(s, " Bytecode.Run:", vm, verr, " Expression.Eval:", walk, werr)
+		}
+	}
+
+	errs := []string{
+		"1/z",
+		"x % z",
+		"1 << -x",
+		"~y",
+		"sqrt(y)",
+		"x + w",
+	}
+	for _, s := range errs {
+		e, err := Compile(s)
+		if err != nil {
+			t.Fatal(err, s, " Compile")
+		}
+		_, werr := e.Eval(vars)
+		_, verr := e.Bytecode().Run(vars)
+		if verr == nil || werr == nil || verr.Error() != werr.Error() {
+			t.Error(s, " Bytecode.Run:", verr, " Expression.Eval:", werr)
+		}
+	}
+
+	// registered functions
+	_ = RegFunction("vmdouble", 1, func(expr ...ExprAST) float64 {
+		return ExprASTResult(expr[0]) * 2
+	})
+	_ = RegisterFunction("vmhalf", 1, func(args ...float64) (float64, error) {
+		return args[0] / 2, nil
+	})
+	defer Unregister("vmdouble")
+	defer Unregister("vmhalf")
+	e, _ := Compile("vmdouble(3) + vmhalf(x)")
+	if r, err := e.Bytecode().Run(vars); err != nil || r != 7.5 {
+		t.Error(err, " Bytecode.Run registered functions:", r)
+	}
+
+	e, _ = CompileWithConfig("x > 1 ? 100 : 1", Config{MaxAbsResult: 10})
+	if _, err := e.Bytecode().Run(vars); err == nil {
+		t.Error("MaxAbsResult should be checked by Bytecode.Run")
+	}
+
+	toks, _ := Parse("2 * (3 + 4)")
+	ast := NewAST(toks, "2 * (3 + 4)")
+	if r, err := CompileBytecode(ast.ParseExpression()).Run(nil); err != nil || r != 14 {
+		t.Error(err, " CompileBytecode:", r)
+	}
+}
+
+const benchExpr = "x * 2 + y / 3 - max(x, y) + (x > y ? sin(x) : cos(y)) + x % 7"
+
+func BenchmarkEvalWalker(b *testing.B) {
+	e, _ := Compile(benchExpr)
+	vars := map[string]float64{"x": 3, "y": 5}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = e.Eval(vars)
+	}
+}
+
+func BenchmarkEvalBytecode(b *testing.B) {
+	e, _ := Compile(benchExpr)
+	prog := e.Bytecode()
+	vars := map[string]float64{"x": 3, "y": 5}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = prog.Run(vars)
+	}
+}
+
+func TestBytecodeMaxSteps(t *testing.T) {
+	vars := map[string]float64{"x": 3, "z": 0}
+	exprs := []string{
+		"z ? 1 / 0 : x + 2",
+		"x ? x * 2 : 1 / 0",
+		"z && (1 + 2)",
+		"x && (1 + 2) * x",
+		"x || (1 + 2)",
+		"z || z + (1 + 2)",
+		"z ?: x + 2",
+		"x ?: 1 / 0",
+		"(x && z) || (z ? 1 : max(x, 2 + 1))",
+		"-abs(x) + 5!",
+	}
+	for _, e := range exprs {
+		for max := 1; max <= 16; max++ {
+			ex, err := CompileWithConfig(e, Config{MaxSteps: max})
+			if err != nil {
+				t.Fatal(err, e)
+			}
+			want, werr := ex.Eval(vars)
+			r, err := ex.Bytecode().Run(vars)
+			if r != want || (err == nil) != (werr == nil) || errors.Is(err, ErrLimit) != errors.Is(werr, ErrLimit) {
+				t.Error(e, " MaxSteps ", max, " Bytecode:", r, err, " want:", want, werr)
+			}
+		}
+	}
+}
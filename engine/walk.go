@@ -0,0 +1,22 @@
+package engine
+
+// Visitor is called by Walk for each node of an AST
+type Visitor interface {
+	// Visit is called with each node, if the returned w is not nil
+	// the children of the node are walked with w, then w.Visit(nil) is called
+	Visit(expr ExprAST) (w Visitor)
+}
+
+// Walk is a Top level function
+// traverses the AST in depth-first order, like go/ast.Walk:
+// it calls v.Visit(expr), and unless that returns nil,
+// walks each of the Children of expr with the returned visitor
+func Walk(expr ExprAST, v Visitor) {
+	if v = v.Visit(expr); v == nil {
+		return
+	}
+	for _, c := range Children(expr) {
+		Walk(c, v)
+	}
+	v.Visit(nil)
+}
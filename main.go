@@ -39,7 +39,7 @@ import (
 // }
 
 // call engine
-func exec(exp string) (r int) {
+func exec(exp string) (r float64) {
 	// input text -> []token
 	toks, err := engine.Parse(exp)
 	if err != nil {